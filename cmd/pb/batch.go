@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// batchJob is a single file conversion of a batch run.
+type batchJob struct {
+	in  string
+	out string
+	err error
+}
+
+func (c *PBConfig) isBatch() bool {
+	return c.OutDir != "" || len(c.Inputs) > 0
+}
+
+func (c *PBConfig) validateBatch() error {
+	if !c.isBatch() {
+		return nil
+	}
+	if c.OutDir == "" {
+		return errors.New("multiple inputs require --out-dir")
+	}
+	if c.Out != "" {
+		return errors.New("cannot use --out with --out-dir")
+	}
+	return nil
+}
+
+// runBatch converts every @file input to a file of the same base name in
+// the output directory, with the extension of the output format. The
+// conversions run in parallel on up to c.Jobs workers and all share the
+// registry built by Run. Every input is attempted and the failures are
+// reported together at the end.
+func (c *PBConfig) runBatch(mt protoreflect.MessageType) error {
+	if err := c.validateBatch(); err != nil {
+		return err
+	}
+	jobs, err := c.batchJobs()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.OutDir, 0777); err != nil {
+		return err
+	}
+
+	workers := c.Jobs
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	ch := make(chan *batchJob)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range ch {
+				job.err = c.convertFile(mt, job.in, job.out)
+			}
+		}()
+	}
+	for _, job := range jobs {
+		ch <- job
+	}
+	close(ch)
+	wg.Wait()
+
+	failed := 0
+	for _, job := range jobs {
+		if job.err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", job.in, job.err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d conversions failed", failed, len(jobs))
+	}
+	return nil
+}
+
+func (c *PBConfig) convertFile(mt protoreflect.MessageType, in, out string) error {
+	b, err := c.readFile(in)
+	if err != nil {
+		return err
+	}
+	if b, err = c.convert(mt, b, getFormat("@"+in, c.InFormat)); err != nil {
+		return err
+	}
+	if b, err = compress(b, c.outCompression(out)); err != nil {
+		return err
+	}
+	return os.WriteFile(out, b, 0666)
+}
+
+// batchJobs expands the @file and @glob inputs into conversion jobs. It is
+// an error for a glob to match no files or for two inputs to map to the
+// same output file.
+func (c *PBConfig) batchJobs() ([]*batchJob, error) {
+	var patterns []string
+	if c.In != "" {
+		patterns = append(patterns, c.In)
+	}
+	patterns = append(patterns, c.Inputs...)
+	if len(patterns) == 0 {
+		return nil, errors.New("no @file inputs for batch conversion")
+	}
+
+	var files []string
+	for _, pattern := range patterns {
+		if !strings.HasPrefix(pattern, "@") {
+			return nil, fmt.Errorf("batch input must be an @file or @glob: %q", pattern)
+		}
+		matches, err := filepath.Glob(pattern[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", pattern[1:], err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %q", pattern[1:])
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+
+	ext := "." + c.outFormat() + compressionExt(c.OutCompression)
+	var jobs []*batchJob
+	seen := map[string]string{}
+	for _, file := range files {
+		base := trimCompressionExt(filepath.Base(file))
+		out := filepath.Join(c.OutDir, strings.TrimSuffix(base, filepath.Ext(base))+ext)
+		if prev, ok := seen[out]; ok {
+			if prev == file {
+				continue
+			}
+			return nil, fmt.Errorf("%s and %s both convert to %s", prev, file, out)
+		}
+		seen[out] = file
+		jobs = append(jobs, &batchJob{in: file, out: out})
+	}
+	return jobs, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunBatch(t *testing.T) {
+	inDir := t.TempDir()
+	outDir := filepath.Join(t.TempDir(), "out")
+	writeFile(t, filepath.Join(inDir, "a.json"), `{"f": "A"}`)
+	writeFile(t, filepath.Join(inDir, "b.json"), `{"f": "B"}`)
+	writeFile(t, filepath.Join(inDir, "c.txt"), `f: "C"`)
+
+	cli := PBConfig{
+		Protoset:    newFDS(t, "testdata/pbtest.pb"),
+		OutDir:      outDir,
+		OutFormat:   "pb",
+		Jobs:        2,
+		MessageType: "BaseMessage",
+		In:          "@" + filepath.Join(inDir, "*.json"),
+		Inputs:      []string{"@" + filepath.Join(inDir, "c.txt")},
+	}
+	require.NoError(t, cli.Run())
+
+	// convert the results back to JSON to check them
+	jsonDir := filepath.Join(t.TempDir(), "json")
+	cli = PBConfig{
+		Protoset:    cli.Protoset,
+		OutDir:      jsonDir,
+		MessageType: "BaseMessage",
+		In:          "@" + filepath.Join(outDir, "*.pb"),
+	}
+	require.NoError(t, cli.Run())
+	requireJSONFileContent(t, `{"f": "A"}`, filepath.Join(jsonDir, "a.json"))
+	requireJSONFileContent(t, `{"f": "B"}`, filepath.Join(jsonDir, "b.json"))
+	requireJSONFileContent(t, `{"f": "C"}`, filepath.Join(jsonDir, "c.json"))
+}
+
+func TestRunBatchFailures(t *testing.T) {
+	inDir := t.TempDir()
+	outDir := t.TempDir()
+	writeFile(t, filepath.Join(inDir, "good.json"), `{"f": "A"}`)
+	writeFile(t, filepath.Join(inDir, "bad.json"), `{"MISSING": "B"}`)
+
+	cli := PBConfig{
+		Protoset:    newFDS(t, "testdata/pbtest.pb"),
+		OutDir:      outDir,
+		OutFormat:   "txt",
+		MessageType: "BaseMessage",
+		In:          "@" + filepath.Join(inDir, "*.json"),
+	}
+	err := cli.Run()
+	require.EqualError(t, err, "1 of 2 conversions failed")
+	require.FileExists(t, filepath.Join(outDir, "good.txt"))
+	require.NoFileExists(t, filepath.Join(outDir, "bad.txt"))
+}
+
+func TestRunBatchErr(t *testing.T) {
+	inDir := t.TempDir()
+	writeFile(t, filepath.Join(inDir, "a.json"), `{"f": "A"}`)
+	writeFile(t, filepath.Join(inDir, "a.txt"), `f: "A"`)
+	glob := "@" + filepath.Join(inDir, "*")
+
+	tests := map[string]PBConfig{
+		"no out-dir":      {In: glob, Inputs: []string{glob}},
+		"out and out-dir": {In: glob, Out: "out.json", OutDir: t.TempDir()},
+		"no match":        {In: "@" + filepath.Join(inDir, "*.pb"), OutDir: t.TempDir()},
+		"not a file":      {In: `{"f": "A"}`, OutDir: t.TempDir()},
+		"same output":     {In: glob, OutDir: t.TempDir()},
+	}
+	for name, cli := range tests {
+		cli := cli
+		t.Run(name, func(t *testing.T) {
+			cli.MessageType = "BaseMessage"
+			cli.Protoset = newFDS(t, "testdata/pbtest.pb")
+			require.Error(t, cli.Run())
+		})
+	}
+}
+
+func writeFile(t *testing.T, filename, content string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(filename, []byte(content), 0666))
+}
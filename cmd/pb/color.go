@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// ANSI escape sequences for syntax highlighting.
+const (
+	colorKey     = "\x1b[34;1m"
+	colorString  = "\x1b[32m"
+	colorNumber  = "\x1b[36m"
+	colorLiteral = "\x1b[35m"
+	colorComment = "\x1b[2m"
+	colorReset   = "\x1b[0m"
+)
+
+// highlight returns json, jsonl and txt output with field names, strings,
+// numbers and literals such as enum names and booleans colorized. Output
+// in other formats is returned unchanged.
+func highlight(b []byte, format string) []byte {
+	if format != "json" && format != "jsonl" && format != "txt" {
+		return b
+	}
+	txt := format == "txt"
+	out := make([]byte, 0, 2*len(b))
+	for i := 0; i < len(b); {
+		c := b[i]
+		end, color := i+1, ""
+		switch {
+		case c == '"' || (txt && c == '\''):
+			end, color = stringEnd(b, i), colorString
+			if !txt && isKey(b, end, txt) {
+				color = colorKey
+			}
+		case c == '-' || isDigit(c):
+			end, color = identEnd(b, i+1), colorNumber
+		case isIdentStart(c):
+			end, color = identEnd(b, i+1), colorLiteral
+			if txt && isKey(b, end, txt) {
+				color = colorKey
+			}
+		case txt && c == '[':
+			// extension and Any type names are keys in brackets
+			if j := bytes.IndexByte(b[i:], ']'); j > 0 && isKey(b, i+j+1, txt) {
+				end, color = i+j+1, colorKey
+			}
+		case txt && c == '#':
+			end, color = i+len(b[i:]), colorComment
+			if j := bytes.IndexByte(b[i:], '\n'); j >= 0 {
+				end = i + j
+			}
+		}
+		if color == "" {
+			out = append(out, b[i:end]...)
+		} else {
+			out = append(out, color...)
+			out = append(out, b[i:end]...)
+			out = append(out, colorReset...)
+		}
+		i = end
+	}
+	return out
+}
+
+// stringEnd returns the offset after the quoted string starting at
+// offset i of b.
+func stringEnd(b []byte, i int) int {
+	quote := b[i]
+	for j := i + 1; j < len(b); j++ {
+		switch b[j] {
+		case '\\':
+			j++
+		case quote, '\n':
+			return j + 1
+		}
+	}
+	return len(b)
+}
+
+// identEnd returns the offset of the end of the identifier or number that
+// continues at offset i of b.
+func identEnd(b []byte, i int) int {
+	for i < len(b) && (isIdentStart(b[i]) || isDigit(b[i]) || b[i] == '.' ||
+		((b[i] == '-' || b[i] == '+') && (b[i-1] == 'e' || b[i-1] == 'E'))) {
+		i++
+	}
+	return i
+}
+
+// isKey returns true if the token ending at offset i of b is a field name,
+// followed by a colon, or for txt by the start of a message value.
+func isKey(b []byte, i int, txt bool) bool {
+	for ; i < len(b); i++ {
+		switch b[i] {
+		case ' ', '\t':
+			continue
+		case ':':
+			return true
+		case '{', '<':
+			return txt
+		}
+		return false
+	}
+	return false
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// page writes b to w through $PAGER, or less by default, if it has more
+// lines than fit on the terminal. less is run with the options FRX unless
+// $LESS is set, so that colors are shown and short output does not need
+// to be dismissed. If the pager cannot be found, b is written to w
+// directly.
+func page(w io.Writer, b []byte, height int) error {
+	if height == 0 || bytes.Count(b, []byte("\n")) < height {
+		_, err := w.Write(b)
+		return err
+	}
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = "less"
+	}
+	cmd := exec.Command("sh", "-c", pager)
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	if os.Getenv("LESS") == "" {
+		cmd.Env = append(os.Environ(), "LESS=FRX")
+	}
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.Is(err, exec.ErrNotFound) || (errors.As(err, &exitErr) && exitErr.ExitCode() == 127) {
+		_, err = w.Write(b)
+	}
+	return err
+}
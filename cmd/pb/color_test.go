@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// uncolor replaces the ANSI escape sequences of highlight with readable
+// markers.
+var uncolor = strings.NewReplacer(
+	colorKey, "<k>",
+	colorString, "<s>",
+	colorNumber, "<n>",
+	colorLiteral, "<l>",
+	colorComment, "<c>",
+	colorReset, "</>",
+)
+
+func TestHighlightJSON(t *testing.T) {
+	in := `{"id": "a\"b", "status": "OK", "n": -1.5e+3, "ok": true, "x": null, "l": ["k", 2]}`
+	want := `{<k>"id"</>: <s>"a\"b"</>, <k>"status"</>: <s>"OK"</>, <k>"n"</>: <n>-1.5e+3</>, <k>"ok"</>: <l>true</>, <k>"x"</>: <l>null</>, <k>"l"</>: [<s>"k"</>, <n>2</>]}`
+	require.Equal(t, want, uncolor.Replace(string(highlight([]byte(in), "json"))))
+}
+
+func TestHighlightText(t *testing.T) {
+	in := `# comment
+id: 'a'
+status: FAILED
+tags: ["x", "y"]
+main {
+  count: 3
+}
+[pbtest.ext]: 1
+detail: {
+  [type.googleapis.com/pbtest.Event]: {}
+}
+`
+	want := `<c># comment</>
+<k>id</>: <s>'a'</>
+<k>status</>: <l>FAILED</>
+<k>tags</>: [<s>"x"</>, <s>"y"</>]
+<k>main</> {
+  <k>count</>: <n>3</>
+}
+<k>[pbtest.ext]</>: <n>1</>
+<k>detail</>: {
+  <k>[type.googleapis.com/pbtest.Event]</>: {}
+}
+`
+	require.Equal(t, want, uncolor.Replace(string(highlight([]byte(in), "txt"))))
+	require.Equal(t, "\x00\x01", string(highlight([]byte("\x00\x01"), "pb")))
+}
+
+func TestUseColor(t *testing.T) {
+	t.Setenv("NO_COLOR", "")
+	require.True(t, useColor("auto", true))
+	require.True(t, useColor("", true))
+	require.False(t, useColor("auto", false))
+	require.True(t, useColor("always", false))
+	require.False(t, useColor("never", true))
+	t.Setenv("NO_COLOR", "1")
+	require.False(t, useColor("auto", true))
+	require.True(t, useColor("always", true))
+}
+
+func TestPage(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "paged")
+	t.Setenv("PAGER", "cat > "+out)
+	t.Setenv("LESS", "")
+	b := []byte("1\n2\n3\n")
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, page(buf, b, 10))
+	require.Equal(t, b, buf.Bytes())
+	require.NoFileExists(t, out)
+
+	require.NoError(t, page(buf, b, 3))
+	got, err := os.ReadFile(out)
+	require.NoError(t, err)
+	require.Equal(t, b, got)
+
+	t.Setenv("PAGER", "no-such-pager-command")
+	buf.Reset()
+	require.NoError(t, page(buf, b, 2))
+	require.Equal(t, b, buf.Bytes())
+
+	t.Setenv("PAGER", "exit 3")
+	require.Error(t, page(buf, b, 2))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressionExts maps file extensions to the compression they imply.
+var compressionExts = map[string]string{
+	".gz":     "gzip",
+	".zst":    "zstd",
+	".sz":     "snappy",
+	".snappy": "snappy",
+}
+
+// compressionMagic maps compression to the magic bytes that start its
+// encoding. Snappy uses the framing format, starting with a stream
+// identifier chunk.
+var compressionMagic = map[string][]byte{
+	"gzip":   {0x1f, 0x8b},
+	"zstd":   {0x28, 0xb5, 0x2f, 0xfd},
+	"snappy": []byte("\xff\x06\x00\x00sNaPpY"),
+}
+
+func (c *PBConfig) inCompression(filename string, b []byte) string {
+	if c.InCompression != "" {
+		return c.InCompression
+	}
+	if compression := compressionFromExt(filename); compression != "" {
+		return compression
+	}
+	for compression, magic := range compressionMagic {
+		if bytes.HasPrefix(b, magic) {
+			return compression
+		}
+	}
+	return "none"
+}
+
+func (c *PBConfig) outCompression(filename string) string {
+	if c.OutCompression != "" {
+		return c.OutCompression
+	}
+	if compression := compressionFromExt(filename); compression != "" {
+		return compression
+	}
+	return "none"
+}
+
+func compressionFromExt(filename string) string {
+	return compressionExts[filepath.Ext(filename)]
+}
+
+// trimCompressionExt returns filename without its compression extension
+// so that the extension before it can be used for the message format, as
+// in "msg.pb.gz".
+func trimCompressionExt(filename string) string {
+	if compressionFromExt(filename) == "" {
+		return filename
+	}
+	return strings.TrimSuffix(filename, filepath.Ext(filename))
+}
+
+// compressionExt returns the file extension for a compression.
+func compressionExt(compression string) string {
+	switch compression {
+	case "gzip":
+		return ".gz"
+	case "zstd":
+		return ".zst"
+	case "snappy":
+		return ".sz"
+	}
+	return ""
+}
+
+// decompress decompresses b, failing if the result is larger than limit
+// bytes. A limit of 0 means no limit.
+func decompress(b []byte, compression string, limit int64) ([]byte, error) {
+	var r io.Reader
+	switch compression {
+	case "none":
+		return b, checkInputSize(len(b), limit)
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		r = zr
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	case "snappy":
+		r = snappy.NewReader(bytes.NewReader(b))
+	default:
+		return nil, fmt.Errorf("unknown compression %q", compression)
+	}
+	b, err := readLimited(r, limit)
+	if errors.Is(err, errInputTooLarge) {
+		return nil, fmt.Errorf("decompressed %s input: %w", compression, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot decompress %s input: %w", compression, err)
+	}
+	return b, nil
+}
+
+func compress(b []byte, compression string) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	var w io.WriteCloser
+	switch compression {
+	case "none":
+		return b, nil
+	case "gzip":
+		w = gzip.NewWriter(buf)
+	case "zstd":
+		zw, err := zstd.NewWriter(buf)
+		if err != nil {
+			return nil, err
+		}
+		w = zw
+	case "snappy":
+		w = snappy.NewBufferedWriter(buf)
+	default:
+		return nil, fmt.Errorf("unknown compression %q", compression)
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
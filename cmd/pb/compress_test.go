@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunCompressed(t *testing.T) {
+	tmpDir := t.TempDir()
+	fds := newFDS(t, "testdata/pbtest.pb")
+
+	for _, ext := range []string{".gz", ".zst", ".sz", ".snappy"} {
+		t.Run(ext, func(t *testing.T) {
+			// compression from output file extension
+			cli := PBConfig{
+				Protoset:    fds,
+				Out:         filepath.Join(tmpDir, "out.pb"+ext),
+				MessageType: "BaseMessage",
+				In:          `{"f": "F"}`,
+			}
+			require.NoError(t, cli.Run())
+			b, err := os.ReadFile(cli.Out)
+			require.NoError(t, err)
+			require.Equal(t, compressionFromExt(ext), cli.inCompression("", b))
+
+			// compression and format from input file extension
+			cli.In = "@" + cli.Out
+			cli.Out = filepath.Join(tmpDir, "out.json")
+			require.NoError(t, cli.Run())
+			requireJSONFileContent(t, `{"f": "F"}`, cli.Out)
+		})
+	}
+}
+
+func TestRunCompressedFlags(t *testing.T) {
+	tmpDir := t.TempDir()
+	fds := newFDS(t, "testdata/pbtest.pb")
+
+	cli := PBConfig{
+		Protoset:       fds,
+		Out:            filepath.Join(tmpDir, "out"),
+		OutFormat:      "jsonl",
+		OutCompression: "zstd",
+		MessageType:    "BaseMessage",
+		In:             `{"f": "F"}`,
+	}
+	require.NoError(t, cli.Run())
+
+	// compression detected from magic bytes
+	cli = PBConfig{
+		Protoset:    fds,
+		Out:         filepath.Join(tmpDir, "out.json"),
+		InFormat:    "jsonl",
+		MessageType: "BaseMessage",
+		In:          "@" + filepath.Join(tmpDir, "out"),
+	}
+	require.NoError(t, cli.Run())
+	requireJSONFileContent(t, `{"f": "F"}`, cli.Out)
+
+	// explicit compression overrides detection
+	cli.InCompression = "gzip"
+	require.Error(t, cli.Run())
+	cli.InCompression = "none"
+	require.Error(t, cli.Run())
+}
+
+func TestRunBatchCompressed(t *testing.T) {
+	inDir := t.TempDir()
+	outDir := t.TempDir()
+	fds := newFDS(t, "testdata/pbtest.pb")
+	writeFile(t, filepath.Join(inDir, "a.json"), `{"f": "A"}`)
+
+	cli := PBConfig{
+		Protoset:       fds,
+		OutDir:         outDir,
+		OutFormat:      "pb",
+		OutCompression: "gzip",
+		MessageType:    "BaseMessage",
+		In:             "@" + filepath.Join(inDir, "a.json"),
+	}
+	require.NoError(t, cli.Run())
+	require.FileExists(t, filepath.Join(outDir, "a.pb.gz"))
+
+	cli = PBConfig{
+		Protoset:    fds,
+		OutDir:      inDir,
+		OutFormat:   "txt",
+		MessageType: "BaseMessage",
+		In:          "@" + filepath.Join(outDir, "a.pb.gz"),
+	}
+	require.NoError(t, cli.Run())
+	b, err := os.ReadFile(filepath.Join(inDir, "a.txt"))
+	require.NoError(t, err)
+	require.Contains(t, string(b), `"A"`)
+}
+
+func TestCompressErr(t *testing.T) {
+	_, err := compress(nil, "lzma")
+	require.Error(t, err)
+	_, err = decompress(nil, "lzma", 0)
+	require.Error(t, err)
+	_, err = decompress([]byte("not gzip"), "gzip", 0)
+	require.Error(t, err)
+}
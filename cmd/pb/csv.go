@@ -0,0 +1,534 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// csvJoin separates the values of repeated fields joined into a single
+// CSV/TSV cell. Separators and backslashes in the values are escaped with
+// a backslash.
+const csvJoin = ';'
+
+// column is a CSV/TSV column holding the value of a field path from the
+// root message of each row.
+type column struct {
+	name string
+	path []protoreflect.FieldDescriptor
+}
+
+// repeated returns true if any field on the column path is repeated.
+func (col column) repeated() bool {
+	return numRepeated(col.path) > 0
+}
+
+// numRepeated returns the number of repeated fields on a field path.
+// Columns with more than one cannot be joined into a single cell and read
+// back, as the values of the inner repeated fields would be spread over
+// the elements of the outer one.
+func numRepeated(path []protoreflect.FieldDescriptor) int {
+	n := 0
+	for _, fd := range path {
+		if fd.IsList() {
+			n++
+		}
+	}
+	return n
+}
+
+func (c *PBConfig) encodeCSV(md protoreflect.MessageDescriptor, messages []proto.Message, format string) ([]byte, error) {
+	columns, err := c.csvColumns(md)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	w := newCSVWriter(buf, format)
+	header := make([]string, len(columns))
+	for i, col := range columns {
+		header[i] = col.name
+	}
+	if err := w.Write(header); err != nil {
+		return nil, err
+	}
+	for _, m := range messages {
+		rows, err := csvRows(m.ProtoReflect(), columns, c.Explode, c.types)
+		if err != nil {
+			return nil, err
+		}
+		if err := w.WriteAll(rows); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// csvRows flattens a message into CSV/TSV rows. There is a single row
+// unless explode is set, in which case there is a row per element of the
+// longest repeated column. Non-repeated values are copied to every row.
+func csvRows(m protoreflect.Message, columns []column, explode bool, types resolver) ([][]string, error) {
+	cells := make([][]string, len(columns))
+	numRows := 1
+	for i, col := range columns {
+		var values []string
+		for _, v := range fieldValues(m, col.path) {
+			if !v.IsValid() {
+				values = append(values, "")
+				continue
+			}
+			s, err := formatValue(col.path[len(col.path)-1], v, types)
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", col.name, err)
+			}
+			values = append(values, s)
+		}
+		switch {
+		case !col.repeated():
+			// a single value or placeholder
+		case !explode:
+			values = []string{joinCells(values)}
+		case len(values) > numRows:
+			numRows = len(values)
+		}
+		cells[i] = values
+	}
+
+	rows := make([][]string, numRows)
+	for r := range rows {
+		rows[r] = make([]string, len(columns))
+		for i, col := range columns {
+			switch {
+			case !col.repeated() && len(cells[i]) == 1:
+				rows[r][i] = cells[i][0]
+			case r < len(cells[i]):
+				rows[r][i] = cells[i][r]
+			}
+		}
+	}
+	return rows, nil
+}
+
+// fieldValues returns the values at the end of a field path, fanning out
+// over the elements of any repeated fields on the path. Unset singular
+// fields have an invalid placeholder value, so that the values of the
+// elements of a repeated message field stay aligned across columns.
+// Empty repeated fields have no values.
+func fieldValues(m protoreflect.Message, path []protoreflect.FieldDescriptor) []protoreflect.Value {
+	fd := path[0]
+	if !m.Has(fd) {
+		if fd.IsList() {
+			return nil
+		}
+		return []protoreflect.Value{{}}
+	}
+	var values []protoreflect.Value
+	if fd.IsList() {
+		list := m.Get(fd).List()
+		for i := 0; i < list.Len(); i++ {
+			values = append(values, list.Get(i))
+		}
+	} else {
+		values = []protoreflect.Value{m.Get(fd)}
+	}
+	if len(path) == 1 {
+		return values
+	}
+	var result []protoreflect.Value
+	for _, v := range values {
+		result = append(result, fieldValues(v.Message(), path[1:])...)
+	}
+	return result
+}
+
+func (c *PBConfig) decodeCSV(mt protoreflect.MessageType, in []byte, format string) ([]proto.Message, error) {
+	if c.Explode {
+		return nil, errors.New("cannot read exploded CSV/TSV rows, only joined repeated values")
+	}
+	records, err := newCSVReader(bytes.NewReader(in), format).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	md := mt.Descriptor()
+	header := records[0]
+	columns := make([]*column, len(header))
+	for i, name := range header {
+		if len(c.Columns) > 0 && !contains(c.Columns, name) {
+			continue
+		}
+		col, err := parseColumn(md, name, true)
+		if err != nil {
+			return nil, err
+		}
+		columns[i] = &col
+	}
+	for _, name := range c.Columns {
+		if !contains(header, name) {
+			return nil, fmt.Errorf("column %s not in header", name)
+		}
+	}
+
+	limits := c.decodeLimits()
+	messages := make([]proto.Message, 0, len(records)-1)
+	for r, record := range records[1:] {
+		m := mt.New()
+		for i, cell := range record {
+			if columns[i] == nil || cell == "" {
+				continue
+			}
+			col := *columns[i]
+			cells := []string{cell}
+			if col.repeated() {
+				cells = splitCells(cell)
+			}
+			if err := setFieldValues(m, col.path, cells, c.types); err != nil {
+				return nil, fmt.Errorf("row %d, column %s: %w", r+1, col.name, err)
+			}
+		}
+		if limits != nil {
+			if err := limits.checkMessage(m, 1); err != nil {
+				return nil, fmt.Errorf("row %d: %w", r+1, err)
+			}
+		}
+		messages = append(messages, m.Interface())
+	}
+	return messages, nil
+}
+
+// setFieldValues parses cells and sets them as the values at the end of a
+// field path. There is a single cell unless the path has a repeated
+// field. The values are spread over the elements of a repeated
+// message field on the path, creating elements as needed, so that
+// setFieldValues is the inverse of fieldValues. Empty cells of singular
+// fields are placeholders for unset fields.
+func setFieldValues(m protoreflect.Message, path []protoreflect.FieldDescriptor, cells []string, types resolver) error {
+	fd := path[0]
+	if len(path) == 1 {
+		if fd.IsList() {
+			list := m.Mutable(fd).List()
+			for _, cell := range cells {
+				v, err := parseValue(fd, cell, list.NewElement(), types)
+				if err != nil {
+					return err
+				}
+				list.Append(v)
+			}
+			return nil
+		}
+		if cells[0] == "" {
+			return nil
+		}
+		v, err := parseValue(fd, cells[0], m.NewField(fd), types)
+		if err != nil {
+			return err
+		}
+		m.Set(fd, v)
+		return nil
+	}
+	if !fd.IsList() {
+		return setFieldValues(m.Mutable(fd).Message(), path[1:], cells, types)
+	}
+	list := m.Mutable(fd).List()
+	for i, cell := range cells {
+		for list.Len() <= i {
+			list.Append(list.NewElement())
+		}
+		if err := setFieldValues(list.Get(i).Message(), path[1:], []string{cell}, types); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// joinCells joins the values of a repeated column into a single cell,
+// escaping separators and backslashes in the values. A single empty value
+// is written as a lone backslash, as an empty cell has no values.
+func joinCells(values []string) string {
+	if len(values) == 1 && values[0] == "" {
+		return `\\`
+	}
+	b := &strings.Builder{}
+	for i, v := range values {
+		if i > 0 {
+			b.WriteByte(csvJoin)
+		}
+		for _, r := range v {
+			if r == csvJoin || r == '\\' {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// splitCells splits a cell of a repeated column into its values. It is
+// the inverse of joinCells.
+func splitCells(cell string) []string {
+	if cell == `\\` {
+		return []string{""}
+	}
+	var values []string
+	b := &strings.Builder{}
+	escaped := false
+	for _, r := range cell {
+		switch {
+		case escaped:
+			b.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == csvJoin:
+			values = append(values, b.String())
+			b.Reset()
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return append(values, b.String())
+}
+
+// csvColumns returns the columns named by the --columns flag or all
+// columns derived from the message descriptor if none are named.
+func (c *PBConfig) csvColumns(md protoreflect.MessageDescriptor) ([]column, error) {
+	if len(c.Columns) == 0 {
+		return deriveColumns(md, nil, map[protoreflect.FullName]bool{}, !c.Explode), nil
+	}
+	columns := make([]column, len(c.Columns))
+	for i, name := range c.Columns {
+		col, err := parseColumn(md, name, !c.Explode)
+		if err != nil {
+			return nil, err
+		}
+		columns[i] = col
+	}
+	return columns, nil
+}
+
+// deriveColumns returns a column for every scalar field reachable from a
+// message descriptor, in field order. Message fields are flattened into
+// the columns of their fields, except for well-known types which are
+// single columns in their JSON form. Map fields and recursive message
+// fields are skipped, as are repeated fields in repeated messages if the
+// columns are joined.
+func deriveColumns(md protoreflect.MessageDescriptor, prefix []protoreflect.FieldDescriptor, seen map[protoreflect.FullName]bool, joined bool) []column {
+	seen[md.FullName()] = true
+	defer delete(seen, md.FullName())
+
+	var columns []column
+	fds := md.Fields()
+	for i := 0; i < fds.Len(); i++ {
+		fd := fds.Get(i)
+		path := append(append([]protoreflect.FieldDescriptor{}, prefix...), fd)
+		switch {
+		case fd.IsMap(), joined && numRepeated(path) > 1:
+			continue
+		case fd.Message() != nil && !isWellKnown(fd.Message()):
+			if !seen[fd.Message().FullName()] {
+				columns = append(columns, deriveColumns(fd.Message(), path, seen, joined)...)
+			}
+		default:
+			columns = append(columns, column{name: columnName(path), path: path})
+		}
+	}
+	return columns
+}
+
+// parseColumn resolves a dotted path of field names or JSON names
+// relative to a message descriptor. The last field on the path must be a
+// scalar field or a well-known type. Joined columns may have only one
+// repeated field on the path.
+func parseColumn(md protoreflect.MessageDescriptor, name string, joined bool) (column, error) {
+	var path []protoreflect.FieldDescriptor
+	for _, part := range strings.Split(name, ".") {
+		if md == nil {
+			return column{}, fmt.Errorf("invalid column %s: %s is not a message", name, columnName(path))
+		}
+		fd := md.Fields().ByName(protoreflect.Name(part))
+		if fd == nil {
+			fd = md.Fields().ByJSONName(part)
+		}
+		if fd == nil {
+			return column{}, fmt.Errorf("invalid column %s: no field %s in %s", name, part, md.FullName())
+		}
+		if fd.IsMap() {
+			return column{}, fmt.Errorf("invalid column %s: map fields are not supported", name)
+		}
+		path = append(path, fd)
+		md = fd.Message()
+		if md != nil && isWellKnown(md) {
+			md = nil
+		}
+	}
+	if md != nil {
+		return column{}, fmt.Errorf("invalid column %s: %s is a message", name, md.FullName())
+	}
+	if joined && numRepeated(path) > 1 {
+		return column{}, fmt.Errorf("invalid column %s: repeated fields in repeated messages can only be written with --explode", name)
+	}
+	return column{name: name, path: path}, nil
+}
+
+func columnName(path []protoreflect.FieldDescriptor) string {
+	names := make([]string, len(path))
+	for i, fd := range path {
+		names[i] = string(fd.Name())
+	}
+	return strings.Join(names, ".")
+}
+
+// wellKnownTypes are the google.protobuf message types with a special
+// JSON form.
+var wellKnownTypes = map[protoreflect.FullName]bool{
+	"google.protobuf.Any":         true,
+	"google.protobuf.Duration":    true,
+	"google.protobuf.Empty":       true,
+	"google.protobuf.FieldMask":   true,
+	"google.protobuf.ListValue":   true,
+	"google.protobuf.Struct":      true,
+	"google.protobuf.Timestamp":   true,
+	"google.protobuf.Value":       true,
+	"google.protobuf.BoolValue":   true,
+	"google.protobuf.BytesValue":  true,
+	"google.protobuf.DoubleValue": true,
+	"google.protobuf.FloatValue":  true,
+	"google.protobuf.Int32Value":  true,
+	"google.protobuf.Int64Value":  true,
+	"google.protobuf.StringValue": true,
+	"google.protobuf.UInt32Value": true,
+	"google.protobuf.UInt64Value": true,
+}
+
+func isWellKnown(md protoreflect.MessageDescriptor) bool {
+	return wellKnownTypes[md.FullName()]
+}
+
+// formatValue formats a singular field value as a CSV/TSV cell. Messages
+// in Any fields are resolved with types.
+func formatValue(fd protoreflect.FieldDescriptor, v protoreflect.Value, types resolver) (string, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return strconv.FormatBool(v.Bool()), nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case protoreflect.FloatKind:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 32), nil
+	case protoreflect.DoubleKind:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64), nil
+	case protoreflect.StringKind:
+		return v.String(), nil
+	case protoreflect.BytesKind:
+		return base64.StdEncoding.EncodeToString(v.Bytes()), nil
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name()), nil
+		}
+		return strconv.Itoa(int(v.Enum())), nil
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		b, err := protojson.MarshalOptions{Resolver: types}.Marshal(v.Message().Interface())
+		if err != nil {
+			return "", err
+		}
+		// Unquote JSON strings such as timestamps and durations.
+		if s, err := strconv.Unquote(string(b)); err == nil {
+			return s, nil
+		}
+		return string(b), nil
+	}
+	return "", fmt.Errorf("unsupported field kind %s", fd.Kind())
+}
+
+// parseValue parses a CSV/TSV cell as a singular field value. It is the
+// inverse of formatValue. Message values are parsed into the message of
+// the given new field value, resolving messages in Any fields with types.
+func parseValue(fd protoreflect.FieldDescriptor, s string, newValue protoreflect.Value, types resolver) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(s)
+		return protoreflect.ValueOfBool(b), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		i, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfInt32(int32(i)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		i, err := strconv.ParseInt(s, 10, 64)
+		return protoreflect.ValueOfInt64(i), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		u, err := strconv.ParseUint(s, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(u)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		u, err := strconv.ParseUint(s, 10, 64)
+		return protoreflect.ValueOfUint64(u), err
+	case protoreflect.FloatKind:
+		f, err := strconv.ParseFloat(s, 32)
+		return protoreflect.ValueOfFloat32(float32(f)), err
+	case protoreflect.DoubleKind:
+		f, err := strconv.ParseFloat(s, 64)
+		return protoreflect.ValueOfFloat64(f), err
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BytesKind:
+		b, err := base64.StdEncoding.DecodeString(s)
+		return protoreflect.ValueOfBytes(b), err
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		i, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("unknown %s value %q", fd.Enum().FullName(), s)
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(i)), nil
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		m := newValue.Message().Interface()
+		o := protojson.UnmarshalOptions{Resolver: types}
+		if err := o.Unmarshal([]byte(s), m); err != nil {
+			// Cells for JSON strings such as timestamps are unquoted.
+			if err := o.Unmarshal([]byte(strconv.Quote(s)), m); err != nil {
+				return protoreflect.Value{}, err
+			}
+		}
+		return newValue, nil
+	}
+	return protoreflect.Value{}, fmt.Errorf("unsupported field kind %s", fd.Kind())
+}
+
+func newCSVWriter(buf *bytes.Buffer, format string) *csv.Writer {
+	w := csv.NewWriter(buf)
+	if format == "tsv" {
+		w.Comma = '\t'
+	}
+	return w
+}
+
+func newCSVReader(r *bytes.Reader, format string) *csv.Reader {
+	cr := csv.NewReader(r)
+	if format == "tsv" {
+		cr.Comma = '\t'
+		cr.LazyQuotes = true
+	}
+	return cr
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const csvRecords = `{"id": "a", "status": "OK", "latencyMs": "12", "tags": ["x", "y"], "items": [{"name": "i1", "count": 1}, {"name": "i2"}], "time": "2021-08-01T10:00:00Z", "labels": {"k": "v"}, "data": "AAE=", "ok": true, "parent": {"id": "p"}}
+{"id": "b", "status": "FAILED", "score": 0.5, "main": {"name": "m"}}
+`
+
+func TestRunCSV(t *testing.T) {
+	tmpDir := t.TempDir()
+	cli := PBConfig{
+		Protoset:    newFDS(t, "testdata/pbtest.pb"),
+		Out:         filepath.Join(tmpDir, "out.csv"),
+		MessageType: "Record",
+		In:          csvRecords,
+		InFormat:    "jsonl",
+	}
+	require.NoError(t, cli.Run())
+	want := `id,status,latency_ms,tags,items.name,items.count,main.name,main.count,time,data,score,ok
+a,OK,12,x;y,i1;i2,1;,,,2021-08-01T10:00:00Z,AAE=,,true
+b,FAILED,,,,,m,,,,0.5,
+`
+	requireFileContent(t, want, cli.Out)
+
+	// Read the CSV back
+	cli.In = "@" + cli.Out
+	cli.InFormat = ""
+	cli.Out = filepath.Join(tmpDir, "out.jsonl")
+	require.NoError(t, cli.Run())
+	b, err := os.ReadFile(cli.Out)
+	require.NoError(t, err)
+	lines := splitLines(b)
+	require.Len(t, lines, 2)
+	require.JSONEq(t, `{"id": "a", "status": "OK", "latencyMs": "12", "tags": ["x", "y"], "items": [{"name": "i1", "count": 1}, {"name": "i2"}], "time": "2021-08-01T10:00:00Z", "data": "AAE=", "ok": true}`, string(lines[0]))
+	require.JSONEq(t, `{"id": "b", "status": "FAILED", "score": 0.5, "main": {"name": "m"}}`, string(lines[1]))
+}
+
+func TestRunCSVRoundTrip(t *testing.T) {
+	tmpDir := t.TempDir()
+	record := `{"tags": ["x;y", "z\\"], "items": [{"name": "a"}, {"name": "b", "count": 2}, {"count": 3}]}`
+	cli := PBConfig{
+		Protoset:    newFDS(t, "testdata/pbtest.pb"),
+		Out:         filepath.Join(tmpDir, "out.csv"),
+		MessageType: "Record",
+		In:          record,
+		Columns:     []string{"items.name", "items.count", "tags"},
+	}
+	require.NoError(t, cli.Run())
+	want := `items.name,items.count,tags
+a;b;,;2;3,x\;y;z\\
+`
+	requireFileContent(t, want, cli.Out)
+
+	cli.In = "@" + cli.Out
+	cli.Out = filepath.Join(tmpDir, "out.json")
+	require.NoError(t, cli.Run())
+	requireJSONFileContent(t, record, cli.Out)
+}
+
+func TestRunCSVRoundTripAnyAndEmpty(t *testing.T) {
+	tmpDir := t.TempDir()
+	event := `{"name": "", "detail": {"@type": "type.googleapis.com/pbtest.Event", "name": "inner"}}`
+	cli := PBConfig{
+		Protoset:    newFDS(t, "testdata/pbtest.pb"),
+		Out:         filepath.Join(tmpDir, "out.csv"),
+		MessageType: "Event",
+		In:          event,
+		Columns:     []string{"name", "detail"},
+	}
+	require.NoError(t, cli.Run())
+	cli.In = "@" + cli.Out
+	cli.Out = filepath.Join(tmpDir, "out.json")
+	require.NoError(t, cli.Run())
+	requireJSONFileContent(t, `{"detail": {"@type": "type.googleapis.com/pbtest.Event", "name": "inner"}}`, cli.Out)
+
+	// a single empty value differs from no values
+	for _, record := range []string{`{"tags": [""]}`, `{}`, `{"items": [{}]}`} {
+		cli := PBConfig{
+			Protoset:    newFDS(t, "testdata/pbtest.pb"),
+			Out:         filepath.Join(tmpDir, "out.csv"),
+			MessageType: "Record",
+			In:          record,
+			Columns:     []string{"tags", "items.name"},
+		}
+		require.NoError(t, cli.Run())
+		cli.In = "@" + cli.Out
+		cli.Out = filepath.Join(tmpDir, "out.json")
+		require.NoError(t, cli.Run())
+		requireJSONFileContent(t, record, cli.Out)
+	}
+}
+
+const nestedRepeatedProto = `
+file: {
+  name: "nested.proto"
+  package: "nested"
+  syntax: "proto3"
+  message_type: {
+    name: "Order"
+    field: { name: "lines" number: 1 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".nested.Line" json_name: "lines" }
+  }
+  message_type: {
+    name: "Line"
+    field: { name: "sku" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING json_name: "sku" }
+    field: { name: "tags" number: 2 label: LABEL_REPEATED type: TYPE_STRING json_name: "tags" }
+  }
+}
+`
+
+func TestRunCSVNestedRepeated(t *testing.T) {
+	fds := &descriptorpb.FileDescriptorSet{}
+	require.NoError(t, prototext.Unmarshal([]byte(nestedRepeatedProto), fds))
+	tmpDir := t.TempDir()
+	order := `{"lines": [{"sku": "a", "tags": ["x", "y"]}, {"sku": "b", "tags": ["z"]}]}`
+
+	// joined columns leave out repeated fields in repeated messages
+	cli := PBConfig{Protoset: fds, Out: filepath.Join(tmpDir, "out.csv"), MessageType: "nested.Order", In: order}
+	require.NoError(t, cli.Run())
+	requireFileContent(t, "lines.sku\na;b\n", cli.Out)
+	cli.In = "@" + cli.Out
+	cli.Out = filepath.Join(tmpDir, "out.json")
+	require.NoError(t, cli.Run())
+	requireJSONFileContent(t, `{"lines": [{"sku": "a"}, {"sku": "b"}]}`, cli.Out)
+
+	// and cannot be named, neither for writing nor for reading
+	cli = PBConfig{Protoset: fds, Out: filepath.Join(tmpDir, "out.csv"), MessageType: "nested.Order", In: order, Columns: []string{"lines.tags"}}
+	err := cli.Run()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "only be written with --explode")
+	cli = PBConfig{Protoset: fds, Out: filepath.Join(tmpDir, "out.json"), MessageType: "nested.Order", In: "lines.tags\nx;y\n", InFormat: "csv"}
+	err = cli.Run()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "only be written with --explode")
+
+	// exploded rows have a row per inner element
+	cli = PBConfig{Protoset: fds, Out: filepath.Join(tmpDir, "out.csv"), MessageType: "nested.Order", In: order, Explode: true}
+	require.NoError(t, cli.Run())
+	requireFileContent(t, "lines.sku,lines.tags\na,x\nb,y\n,z\n", cli.Out)
+}
+
+func TestRunTSVColumnsExplode(t *testing.T) {
+	tmpDir := t.TempDir()
+	cli := PBConfig{
+		Protoset:    newFDS(t, "testdata/pbtest.pb"),
+		Out:         filepath.Join(tmpDir, "out.tsv"),
+		MessageType: "Record",
+		In:          csvRecords,
+		InFormat:    "jsonl",
+		Columns:     []string{"items.name", "id", "parent.id", "tags"},
+		Explode:     true,
+	}
+	require.NoError(t, cli.Run())
+	want := "items.name\tid\tparent.id\ttags\n" +
+		"i1\ta\tp\tx\n" +
+		"i2\ta\tp\ty\n" +
+		"\tb\t\t\n"
+	requireFileContent(t, want, cli.Out)
+
+	// Exploded rows cannot be read back
+	cli.In = "@" + cli.Out
+	cli.InFormat = ""
+	require.Error(t, cli.Run())
+}
+
+func TestRunCSVColumnsInput(t *testing.T) {
+	tmpDir := t.TempDir()
+	cli := PBConfig{
+		Protoset:    newFDS(t, "testdata/pbtest.pb"),
+		Out:         filepath.Join(tmpDir, "out.jsonl"),
+		MessageType: "Record",
+		In:          "id,status,latencyMs\na,OK,5\n",
+		InFormat:    "csv",
+		Columns:     []string{"id", "latencyMs"},
+	}
+	require.NoError(t, cli.Run())
+	requireJSONFileContent(t, `{"id": "a", "latencyMs": "5"}`, cli.Out)
+}
+
+func TestCSVErr(t *testing.T) {
+	tmpDir := t.TempDir()
+	tests := map[string]PBConfig{
+		"unknown column": {In: `{"id": "a"}`, OutFormat: "csv", Columns: []string{"nope"}},
+		"message column": {In: `{"id": "a"}`, OutFormat: "csv", Columns: []string{"main"}},
+		"map column":     {In: `{"id": "a"}`, OutFormat: "csv", Columns: []string{"labels"}},
+		"scalar path":    {In: `{"id": "a"}`, OutFormat: "csv", Columns: []string{"id.x"}},
+		"missing header": {In: "id\na\n", InFormat: "csv", Columns: []string{"status"}},
+		"bad enum":       {In: "status\nNOPE\n", InFormat: "csv"},
+		"bad int":        {In: "latency_ms\nx\n", InFormat: "csv"},
+		"bad timestamp":  {In: "time\nx\n", InFormat: "csv"},
+		"joined single":  {In: "main.count\n1;2\n", InFormat: "csv"},
+	}
+	for name, cli := range tests {
+		cli := cli
+		t.Run(name, func(t *testing.T) {
+			cli.Protoset = newFDS(t, "testdata/pbtest.pb")
+			cli.MessageType = "Record"
+			cli.Out = filepath.Join(tmpDir, "out")
+			require.Error(t, cli.Run())
+		})
+	}
+}
+
+func requireFileContent(t *testing.T, want string, gotFile string) {
+	t.Helper()
+	b, err := os.ReadFile(gotFile)
+	require.NoError(t, err)
+	require.Equal(t, want, string(b))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"foxygo.at/protog/registry"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+type DepsCmd struct {
+	Protoset *descriptorpb.FileDescriptorSet `short:"P" required:"" help:"Protoset containing the types"`
+	Out      string                          `short:"o" help:"Output file name"`
+	Reverse  bool                            `short:"r" help:"List the types depending on the type instead: what breaks if it changes"`
+	Direct   bool                            `help:"List only direct dependencies"`
+	DOT      bool                            `name:"dot" help:"Write the dependency graph in the DOT language of Graphviz"`
+	TypeName string                          `arg:"" help:"Full name of the message, enum or service"`
+}
+
+// Run lists the types a type depends on, or with Reverse the types
+// depending on it, through fields, extensions, methods and Any type URLs
+// in options. Options that cannot be decoded are reported and skipped.
+func (c *DepsCmd) Run() error {
+	files, err := registry.NewFiles(c.Protoset)
+	if err != nil {
+		return err
+	}
+	name := protoreflect.FullName(c.TypeName)
+	if _, err := files.FindDescriptorByName(name); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	g, err := registry.NewGraph(files)
+	if err != nil {
+		// the graph lacks only the references of undecodable options
+		fmt.Fprintf(os.Stderr, "incomplete dependencies: %v\n", err)
+	}
+	var names []protoreflect.FullName
+	switch {
+	case c.Reverse && c.Direct:
+		names = g.ReferencedBy(name)
+	case c.Reverse:
+		names = g.TransitiveReferencedBy(name)
+	case c.Direct:
+		names = g.References(name)
+	default:
+		names = g.TransitiveReferences(name)
+	}
+
+	b := &bytes.Buffer{}
+	if c.DOT {
+		if err := g.WriteTypesDOT(b, append(names, name)); err != nil {
+			return err
+		}
+	} else {
+		for _, n := range names {
+			fmt.Fprintln(b, n)
+		}
+	}
+	pb := &PBConfig{Out: c.Out, OutFormat: "txt"}
+	return pb.writeOutput(b.Bytes())
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeps(t *testing.T) {
+	tests := map[string]struct {
+		cmd  DepsCmd
+		want string
+	}{
+		"references": {
+			cmd:  DepsCmd{TypeName: "pbtest.Record"},
+			want: "google.protobuf.Timestamp\npbtest.Record\npbtest.Record.Item\npbtest.Record.Status\n",
+		},
+		"referenced by": {
+			cmd:  DepsCmd{TypeName: "google.protobuf.Duration", Reverse: true},
+			want: "pbtest.Event\n",
+		},
+		"direct": {
+			cmd:  DepsCmd{TypeName: "google.protobuf.Value", Reverse: true, Direct: true},
+			want: "google.protobuf.ListValue\ngoogle.protobuf.Struct\npbtest.Event\n",
+		},
+		"dot": {
+			cmd:  DepsCmd{TypeName: "pbtest.Record.Status", Reverse: true, DOT: true},
+			want: "digraph \"types\" {\n  \"pbtest.Record\" [shape=box];\n  \"pbtest.Record.Status\" [shape=ellipse];\n  \"pbtest.Record\" -> \"pbtest.Record\";\n  \"pbtest.Record\" -> \"pbtest.Record.Status\";\n}\n",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cmd := tc.cmd
+			cmd.Protoset = newFDS(t, "testdata/pbtest.pb")
+			cmd.Out = filepath.Join(t.TempDir(), "deps.txt")
+			require.NoError(t, cmd.Run())
+			b, err := os.ReadFile(cmd.Out)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, string(b))
+		})
+	}
+}
+
+func TestDepsUnknownType(t *testing.T) {
+	cmd := DepsCmd{Protoset: newFDS(t, "testdata/pbtest.pb"), TypeName: "pbtest.Unknown"}
+	require.Error(t, cmd.Run())
+}
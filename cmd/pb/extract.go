@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"os"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+type ExtractCmd struct {
+	Out         string `short:"o" help:"Output file name"`
+	OutFormat   string `short:"O" help:"Output format (j[son], p[b], t[xt])" enum:"json,pb,txt,j,p,t," default:"pb"`
+	BuiltinDeps bool   `help:"Take dependencies missing from the binary from the files built into pb, which may be of another version"`
+	Binary      string `arg:"" help:"Go binary built with protobuf-go" type:"existingfile"`
+}
+
+// Run scans a Go binary for the serialized FileDescriptorProtos that
+// protobuf-go generated code embeds, and writes them as a
+// FileDescriptorSet in dependency order.
+func (c *ExtractCmd) Run() error {
+	data, err := binaryData(c.Binary)
+	if err != nil {
+		return err
+	}
+	fds, err := extractDescriptors(data, c.BuiltinDeps)
+	if err != nil {
+		return err
+	}
+	pb := &PBConfig{Out: c.Out, OutFormat: c.OutFormat}
+	if pb.OutFormat == "" {
+		pb.OutFormat = "pb"
+	}
+	marshal, err := pb.marshaler(protoregistry.GlobalTypes)
+	if err != nil {
+		return err
+	}
+	b, err := marshal(fds)
+	if err != nil {
+		return err
+	}
+	return pb.writeOutput(b)
+}
+
+// binaryData returns the contents of the data sections of an ELF binary,
+// where Go stores the raw descriptors, or the whole file for other
+// binary formats.
+func binaryData(filename string) ([]byte, error) {
+	f, err := elf.Open(filename)
+	if err != nil {
+		return os.ReadFile(filename)
+	}
+	defer f.Close()
+	var result []byte
+	for _, s := range f.Sections {
+		if s.Type != elf.SHT_PROGBITS || s.Flags&elf.SHF_EXECINSTR != 0 {
+			continue
+		}
+		b, err := s.Data()
+		if err != nil {
+			return nil, fmt.Errorf("cannot read section %s: %w", s.Name, err)
+		}
+		result = append(result, b...)
+	}
+	return result, nil
+}
+
+// extractDescriptors finds the serialized FileDescriptorProtos in data,
+// validates them with protodesc and returns them in dependency order.
+func extractDescriptors(data []byte, builtinDeps bool) (*descriptorpb.FileDescriptorSet, error) {
+	files := map[string]*descriptorpb.FileDescriptorProto{}
+	suffix := []byte(".proto")
+	for i := 0; ; {
+		j := bytes.Index(data[i:], suffix)
+		if j < 0 {
+			break
+		}
+		i += j + len(suffix)
+		if fdp := descriptorAt(data, i); fdp != nil && files[fdp.GetName()] == nil {
+			files[fdp.GetName()] = fdp
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no file descriptors found")
+	}
+	return sortDescriptors(files, builtinDeps)
+}
+
+// descriptorAt parses the FileDescriptorProto whose name ends at offset
+// nameEnd of data. Raw descriptors start with their name field, so the
+// name is found by searching backwards for its tag and length. nil is
+// returned if there is no valid descriptor.
+func descriptorAt(data []byte, nameEnd int) *descriptorpb.FileDescriptorProto {
+	for nameLen := 1; nameLen <= nameEnd; nameLen++ {
+		c := data[nameEnd-nameLen]
+		if c < 0x20 || c > 0x7e {
+			return nil
+		}
+		start := nameEnd - nameLen - protowire.SizeVarint(uint64(nameLen)) - 1
+		if start < 0 || data[start] != 0x0a {
+			continue
+		}
+		if v, n := protowire.ConsumeVarint(data[start+1:]); n < 0 || v != uint64(nameLen) {
+			continue
+		}
+		b := data[start:]
+		b = b[:fileDescriptorLen(b)]
+		fdp := &descriptorpb.FileDescriptorProto{}
+		if err := proto.Unmarshal(b, fdp); err != nil {
+			return nil
+		}
+		return fdp
+	}
+	return nil
+}
+
+// fileDescriptorLen returns the length of the FileDescriptorProto at the
+// start of b, which ends at the first field that is not a valid
+// FileDescriptorProto field or at a second name field.
+func fileDescriptorLen(b []byte) int {
+	n := 0
+	for n < len(b) {
+		num, typ, tagLen := protowire.ConsumeTag(b[n:])
+		if tagLen < 0 || !isFileDescriptorField(num, typ) || (num == 1 && n > 0) {
+			break
+		}
+		valueLen := protowire.ConsumeFieldValue(num, typ, b[n+tagLen:])
+		if valueLen < 0 {
+			break
+		}
+		n += tagLen + valueLen
+	}
+	return n
+}
+
+func isFileDescriptorField(num protowire.Number, typ protowire.Type) bool {
+	switch num {
+	case 1, 2, 3, 4, 5, 6, 7, 8, 9, 12:
+		return typ == protowire.BytesType
+	case 10, 11:
+		return typ == protowire.VarintType || typ == protowire.BytesType
+	case 14:
+		return typ == protowire.VarintType
+	}
+	return false
+}
+
+// sortDescriptors validates the files and returns them with every file
+// after its dependencies. Files that are not valid, or that depend on
+// files that are not valid or missing, are reported on stderr and left
+// out. With builtinDeps, missing dependencies are taken from the files
+// built into pb instead, with a notice on stderr.
+func sortDescriptors(files map[string]*descriptorpb.FileDescriptorProto, builtinDeps bool) (*descriptorpb.FileDescriptorSet, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	reg := &protoregistry.Files{}
+	fds := &descriptorpb.FileDescriptorSet{}
+	failed := map[string]error{}
+	adding := map[string]bool{}
+	var add func(name string) error
+	add = func(name string) error {
+		if _, err := reg.FindFileByPath(name); err == nil {
+			return nil
+		}
+		if err, ok := failed[name]; ok {
+			return err
+		}
+		if adding[name] {
+			return fmt.Errorf("import cycle with %s", name)
+		}
+		adding[name] = true
+		err := addDescriptor(name, files, builtinDeps, reg, fds, add)
+		if err != nil {
+			failed[name] = err
+		}
+		return err
+	}
+	for _, name := range names {
+		if err := add(name); err != nil {
+			fmt.Fprintf(os.Stderr, "skipping %s: %v\n", name, err)
+		}
+	}
+	if len(fds.File) == 0 {
+		return nil, fmt.Errorf("no valid file descriptors found")
+	}
+	return fds, nil
+}
+
+// addDescriptor adds the dependencies of a file and then the file itself
+// to reg and fds. With builtinDeps, dependencies not in files are taken
+// from the files compiled into pb.
+func addDescriptor(name string, files map[string]*descriptorpb.FileDescriptorProto, builtinDeps bool, reg *protoregistry.Files, fds *descriptorpb.FileDescriptorSet, add func(string) error) error {
+	fdp, ok := files[name]
+	if !ok {
+		if !builtinDeps {
+			return fmt.Errorf("missing file %s", name)
+		}
+		fd, err := protoregistry.GlobalFiles.FindFileByPath(name)
+		if err != nil {
+			return fmt.Errorf("missing file %s", name)
+		}
+		fmt.Fprintf(os.Stderr, "using built-in %s missing from the binary\n", name)
+		fdp = protodesc.ToFileDescriptorProto(fd)
+	}
+	for _, dep := range fdp.GetDependency() {
+		if err := add(dep); err != nil {
+			return fmt.Errorf("dependency %s: %w", dep, err)
+		}
+	}
+	fd, err := protodesc.NewFile(fdp, reg)
+	if err != nil {
+		return err
+	}
+	if err := reg.RegisterFile(fd); err != nil {
+		return err
+	}
+	fds.File = append(fds.File, fdp)
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestExtractDescriptors(t *testing.T) {
+	// The test binary is a Go binary with the descriptors of the
+	// well-known types embedded.
+	binary, err := os.Executable()
+	require.NoError(t, err)
+	cmd := ExtractCmd{
+		Out:    filepath.Join(t.TempDir(), "set.pb"),
+		Binary: binary,
+	}
+	require.NoError(t, cmd.Run())
+
+	fds := newFDS(t, cmd.Out)
+	_, err = protodesc.NewFiles(fds)
+	require.NoError(t, err)
+	index := map[string]int{}
+	for i, f := range fds.File {
+		index[f.GetName()] = i
+	}
+	require.Contains(t, index, "google/protobuf/descriptor.proto")
+	require.Contains(t, index, "google/protobuf/compiler/plugin.proto")
+	require.Less(t, index["google/protobuf/descriptor.proto"], index["google/protobuf/compiler/plugin.proto"])
+}
+
+func TestExtractDescriptorsData(t *testing.T) {
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("a/b.proto"),
+		Package:    proto.String("ab"),
+		Dependency: []string{"google/protobuf/empty.proto"},
+		Syntax:     proto.String("proto3"),
+	}
+	raw, err := proto.Marshal(fdp)
+	require.NoError(t, err)
+	invalid, err := proto.Marshal(&descriptorpb.FileDescriptorProto{
+		Name:       proto.String("c.proto"),
+		Dependency: []string{"missing.proto"},
+	})
+	require.NoError(t, err)
+
+	// surround the raw descriptors with noise and a second name field
+	data := []byte("noise.proto\x00\x0a\x05x.proto")
+	data = append(data, raw...)
+	data = append(data, raw[:3]...)
+	data = append(data, invalid...)
+	data = append(data, "\xff\xff"...)
+
+	// a/b.proto depends on a file missing from the data
+	_, err = extractDescriptors(data, false)
+	require.Error(t, err)
+
+	fds, err := extractDescriptors(data, true)
+	require.NoError(t, err)
+	require.Len(t, fds.File, 2)
+	require.Equal(t, "google/protobuf/empty.proto", fds.File[0].GetName())
+	require.True(t, proto.Equal(fdp, fds.File[1]))
+
+	_, err = extractDescriptors([]byte("nothing.proto here"), true)
+	require.Error(t, err)
+	_, err = extractDescriptors(invalid, true)
+	require.Error(t, err)
+}
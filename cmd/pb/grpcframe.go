@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+)
+
+// gRPC messages are framed on the wire with a flags byte and a 4 byte
+// big-endian length. grpc-web adds trailer frames, and grpc-web-text
+// base64 encodes the frames.
+const (
+	frameHeaderLen  = 5
+	frameCompressed = 0x01
+	frameTrailer    = 0x80
+)
+
+// grpcEncoding returns the compression of compressed message frames.
+func (c *PBConfig) grpcEncoding() string {
+	if c.GRPCEncoding == "" {
+		return "gzip"
+	}
+	return c.GRPCEncoding
+}
+
+// splitFrames splits gRPC framed input into the messages of its frames,
+// decompressing any compressed frames. grpc-web trailer frames are
+// skipped.
+func (c *PBConfig) splitFrames(b []byte) ([][]byte, error) {
+	var result [][]byte
+	for i := 1; len(b) > 0; i++ {
+		if len(b) < frameHeaderLen {
+			return nil, fmt.Errorf("frame %d: truncated header", i)
+		}
+		flags := b[0]
+		size := binary.BigEndian.Uint32(b[1:frameHeaderLen])
+		b = b[frameHeaderLen:]
+		if uint64(size) > uint64(len(b)) {
+			return nil, fmt.Errorf("frame %d: truncated, want %d bytes, have %d", i, size, len(b))
+		}
+		payload := b[:size]
+		b = b[size:]
+		if flags&frameTrailer != 0 {
+			continue
+		}
+		if flags&frameCompressed != 0 {
+			var err error
+			if payload, err = decompress(payload, c.grpcEncoding(), c.MaxInputBytes); err != nil {
+				return nil, fmt.Errorf("frame %d: %w", i, err)
+			}
+		}
+		result = append(result, payload)
+	}
+	return result, nil
+}
+
+// appendFrame appends a gRPC frame holding message to dst, compressing the
+// message if --grpc-compress is set.
+func (c *PBConfig) appendFrame(dst, message []byte) ([]byte, error) {
+	var flags byte
+	if c.GRPCCompress {
+		var err error
+		if message, err = compress(message, c.grpcEncoding()); err != nil {
+			return nil, err
+		}
+		flags |= frameCompressed
+	}
+	header := [frameHeaderLen]byte{flags}
+	binary.BigEndian.PutUint32(header[1:], uint32(len(message)))
+	return append(append(dst, header[:]...), message...), nil
+}
+
+// decodeGRPCWebText decodes grpc-web-text input. Each group of 4 base64
+// characters is decoded separately as a grpc-web-text body may be the
+// concatenation of several padded base64 chunks.
+func decodeGRPCWebText(b []byte) ([]byte, error) {
+	b = bytes.Join(bytes.Fields(b), nil)
+	if len(b)%4 != 0 {
+		return nil, fmt.Errorf("invalid grpc-web-text length %d", len(b))
+	}
+	result := make([]byte, 0, len(b)/4*3)
+	var group [3]byte
+	for i := 0; i < len(b); i += 4 {
+		n, err := base64.StdEncoding.Decode(group[:], b[i:i+4])
+		if err != nil {
+			return nil, fmt.Errorf("invalid grpc-web-text: %w", err)
+		}
+		result = append(result, group[:n]...)
+	}
+	return result, nil
+}
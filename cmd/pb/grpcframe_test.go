@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunGRPCFrames(t *testing.T) {
+	tmpDir := t.TempDir()
+	fds := newFDS(t, "testdata/pbtest.pb")
+
+	for _, format := range []string{"grpc", "grpc-web-text"} {
+		for _, compress := range []bool{false, true} {
+			cli := PBConfig{
+				Protoset:     fds,
+				Out:          filepath.Join(tmpDir, "out"),
+				OutFormat:    format,
+				GRPCCompress: compress,
+				MessageType:  "BaseMessage",
+				In:           `{"f": "A"}` + "\n" + `{"f": "B"}`,
+				InFormat:     "jsonl",
+			}
+			require.NoError(t, cli.Run())
+
+			cli = PBConfig{
+				Protoset:    fds,
+				Out:         filepath.Join(tmpDir, "out.jsonl"),
+				MessageType: "BaseMessage",
+				In:          "@" + filepath.Join(tmpDir, "out"),
+				InFormat:    format,
+			}
+			require.NoError(t, cli.Run())
+			b, err := os.ReadFile(cli.Out)
+			require.NoError(t, err)
+			lines := splitLines(b)
+			require.Len(t, lines, 2)
+			require.JSONEq(t, `{"f": "A"}`, string(lines[0]))
+			require.JSONEq(t, `{"f": "B"}`, string(lines[1]))
+		}
+	}
+}
+
+func TestGRPCWebTextChunks(t *testing.T) {
+	cli := PBConfig{}
+	// a message frame and a trailer frame, base64 encoded separately
+	msg := base64.StdEncoding.EncodeToString([]byte("\x00\x00\x00\x00\x03\x0a\x01A"))
+	trailer := base64.StdEncoding.EncodeToString([]byte("\x80\x00\x00\x00\x0fgrpc-status: 0\n"))
+	b, err := decodeGRPCWebText([]byte(msg + "\n" + trailer))
+	require.NoError(t, err)
+	records, err := cli.splitFrames(b)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("\x0a\x01A")}, records)
+}
+
+func TestGRPCFramesErr(t *testing.T) {
+	cli := PBConfig{}
+	_, err := cli.splitFrames([]byte("\x00\x00\x00"))
+	require.Error(t, err)
+	_, err = cli.splitFrames([]byte("\x00\x00\x00\x00\x05ab"))
+	require.Error(t, err)
+	_, err = cli.splitFrames([]byte("\x01\x00\x00\x00\x02ab"))
+	require.Error(t, err)
+	_, err = decodeGRPCWebText([]byte("abc"))
+	require.Error(t, err)
+	_, err = decodeGRPCWebText([]byte("ab!c"))
+	require.Error(t, err)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+type JSONSchemaCmd struct {
+	Protoset    *descriptorpb.FileDescriptorSet `short:"P" help:"Protoset containing Message to be described"`
+	Out         string                          `short:"o" help:"Output file name"`
+	MessageType string                          `arg:"" help:"Message type to be described"`
+}
+
+type schema map[string]interface{}
+
+// Run writes a JSON Schema (draft 2020-12) of the protojson encoding of a
+// message type. Every message and enum type is defined once in $defs and
+// referenced from there, so recursive types are supported.
+func (c *JSONSchemaCmd) Run() error {
+	pb := &PBConfig{Protoset: c.Protoset, Out: c.Out, MessageType: c.MessageType}
+	mt, err := pb.messageType()
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(jsonSchema(mt.Descriptor()), "", "  ")
+	if err != nil {
+		return err
+	}
+	return pb.writeOutput(append(b, '\n'))
+}
+
+func jsonSchema(md protoreflect.MessageDescriptor) schema {
+	defs := schema{}
+	root := schemaRef(md, defs)
+	root["$schema"] = jsonSchemaDraft
+	root["$defs"] = defs
+	return root
+}
+
+// schemaRef returns a reference to the definition of the message or enum
+// d, adding the definition to defs first if needed. Well-known types with
+// a special JSON encoding are also defined in defs.
+func schemaRef(d protoreflect.Descriptor, defs schema) schema {
+	name := string(d.FullName())
+	if _, ok := defs[name]; !ok {
+		defs[name] = nil // placeholder for recursive references
+		switch d := d.(type) {
+		case protoreflect.MessageDescriptor:
+			defs[name] = messageSchema(d, defs)
+		case protoreflect.EnumDescriptor:
+			defs[name] = enumSchema(d)
+		}
+	}
+	return schema{"$ref": "#/$defs/" + name}
+}
+
+// messageSchema returns the schema of a message. protojson accepts both
+// the JSON name and the original name of a field, but not both at once,
+// and at most one field of a oneof.
+func messageSchema(md protoreflect.MessageDescriptor, defs schema) schema {
+	if s := wellKnownSchema(md, defs); s != nil {
+		return s
+	}
+	properties := schema{}
+	exclusive := map[string][]string{}
+	var required []interface{}
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		names := jsonNames(fd)
+		s := nullable(fieldSchema(fd, defs))
+		for _, name := range names {
+			properties[name] = s
+			exclusive[name] = append(exclusive[name], excluding(names, name)...)
+		}
+		if oneof := fd.ContainingOneof(); oneof != nil && !oneof.IsSynthetic() {
+			for j := 0; j < oneof.Fields().Len(); j++ {
+				if other := oneof.Fields().Get(j); other != fd {
+					for _, name := range names {
+						exclusive[name] = append(exclusive[name], jsonNames(other)...)
+					}
+				}
+			}
+		}
+		if fd.Cardinality() == protoreflect.Required {
+			required = append(required, requiredSchema(names))
+		}
+	}
+	s := schema{
+		"type":                 "object",
+		"title":                string(md.Name()),
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	dependent := schema{}
+	for name, others := range exclusive {
+		if len(others) == 0 {
+			continue
+		}
+		anyOf := make([]interface{}, len(others))
+		for i, other := range others {
+			anyOf[i] = schema{"required": []string{other}}
+		}
+		dependent[name] = schema{"not": schema{"anyOf": anyOf}}
+	}
+	if len(dependent) > 0 {
+		s["dependentSchemas"] = dependent
+	}
+	if len(required) > 0 {
+		s["allOf"] = required
+	}
+	return s
+}
+
+// jsonNames returns the JSON name of a field followed by its original name
+// if that is different.
+func jsonNames(fd protoreflect.FieldDescriptor) []string {
+	if fd.JSONName() == string(fd.Name()) {
+		return []string{fd.JSONName()}
+	}
+	return []string{fd.JSONName(), string(fd.Name())}
+}
+
+func excluding(names []string, name string) []string {
+	var result []string
+	for _, n := range names {
+		if n != name {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+func requiredSchema(names []string) schema {
+	if len(names) == 1 {
+		return schema{"required": names}
+	}
+	anyOf := make([]interface{}, len(names))
+	for i, name := range names {
+		anyOf[i] = schema{"required": []string{name}}
+	}
+	return schema{"anyOf": anyOf}
+}
+
+func fieldSchema(fd protoreflect.FieldDescriptor, defs schema) schema {
+	switch {
+	case fd.IsMap():
+		return schema{
+			"type":                 "object",
+			"propertyNames":        mapKeySchema(fd.MapKey()),
+			"additionalProperties": singularSchema(fd.MapValue(), defs),
+		}
+	case fd.IsList():
+		return schema{"type": "array", "items": singularSchema(fd, defs)}
+	}
+	return singularSchema(fd, defs)
+}
+
+// nullable returns a schema also accepting null, which protojson reads as
+// an unset field.
+func nullable(s schema) schema {
+	if s["type"] == "null" || len(s) == 0 {
+		return s
+	}
+	return schema{"anyOf": []schema{{"type": "null"}, s}}
+}
+
+// mapKeySchema returns the schema of the JSON object keys of a map, which
+// are always strings.
+func mapKeySchema(fd protoreflect.FieldDescriptor) schema {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return schema{"enum": []string{"true", "false"}}
+	case protoreflect.StringKind:
+		return schema{"type": "string"}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return schema{"pattern": "^[0-9]+$"}
+	}
+	return schema{"pattern": "^-?[0-9]+$"}
+}
+
+func singularSchema(fd protoreflect.FieldDescriptor, defs schema) schema {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return schemaRef(fd.Message(), defs)
+	case protoreflect.EnumKind:
+		if fd.Enum().FullName() == "google.protobuf.NullValue" {
+			return schema{"type": "null"}
+		}
+		return schemaRef(fd.Enum(), defs)
+	}
+	return scalarSchema(fd.Kind())
+}
+
+// scalarSchema returns the schema of a scalar kind. 64 bit integers are
+// encoded as strings by protojson, but numbers are accepted too, as are
+// strings for 32 bit integers. Floating point numbers can be the strings
+// "NaN", "Infinity" and "-Infinity".
+func scalarSchema(kind protoreflect.Kind) schema {
+	switch kind {
+	case protoreflect.BoolKind:
+		return schema{"type": "boolean"}
+	case protoreflect.StringKind:
+		return schema{"type": "string"}
+	case protoreflect.BytesKind:
+		return schema{"type": "string", "contentEncoding": "base64"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return schema{"type": []string{"integer", "string"}, "format": "int32", "pattern": "^-?[0-9]+$", "minimum": math.MinInt32, "maximum": math.MaxInt32}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return schema{"type": []string{"integer", "string"}, "format": "uint32", "pattern": "^[0-9]+$", "minimum": 0, "maximum": math.MaxUint32}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return schema{"type": []string{"string", "integer"}, "format": "int64", "pattern": "^-?[0-9]+$"}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return schema{"type": []string{"string", "integer"}, "format": "uint64", "pattern": "^[0-9]+$", "minimum": 0}
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return schema{"anyOf": []schema{
+			{"type": "number"},
+			{"enum": []string{"NaN", "Infinity", "-Infinity"}},
+		}}
+	}
+	return schema{}
+}
+
+// enumSchema returns the schema of an enum, encoded by protojson as the
+// name of the value. Numbers are accepted too, also for unknown values.
+func enumSchema(ed protoreflect.EnumDescriptor) schema {
+	values := ed.Values()
+	names := make([]string, values.Len())
+	for i := range names {
+		names[i] = string(values.Get(i).Name())
+	}
+	return schema{"title": string(ed.Name()), "anyOf": []schema{
+		{"type": "string", "enum": names},
+		{"type": "integer", "minimum": math.MinInt32, "maximum": math.MaxInt32},
+	}}
+}
+
+// wellKnownSchema returns the schema of the well-known types that have a
+// special JSON encoding, or nil for all other messages.
+func wellKnownSchema(md protoreflect.MessageDescriptor, defs schema) schema {
+	switch md.FullName() {
+	case "google.protobuf.Timestamp":
+		return schema{"type": "string", "format": "date-time"}
+	case "google.protobuf.Duration":
+		return schema{"type": "string", "pattern": `^-?[0-9]+(\.[0-9]{1,9})?s$`}
+	case "google.protobuf.FieldMask":
+		return schema{"type": "string"}
+	case "google.protobuf.Struct":
+		return schema{"type": "object", "additionalProperties": schemaRef(md.Fields().ByName("fields").MapValue().Message(), defs)}
+	case "google.protobuf.Value":
+		return schema{}
+	case "google.protobuf.ListValue":
+		return schema{"type": "array"}
+	case "google.protobuf.Empty":
+		return schema{"type": "object", "maxProperties": 0}
+	case "google.protobuf.Any":
+		// The fields of the packed message are inlined next to "@type".
+		return schema{"type": "object", "properties": schema{"@type": schema{"type": "string"}}, "required": []string{"@type"}}
+	case "google.protobuf.DoubleValue", "google.protobuf.FloatValue",
+		"google.protobuf.Int64Value", "google.protobuf.UInt64Value",
+		"google.protobuf.Int32Value", "google.protobuf.UInt32Value",
+		"google.protobuf.BoolValue", "google.protobuf.StringValue",
+		"google.protobuf.BytesValue":
+		return scalarSchema(md.Fields().ByName("value").Kind())
+	}
+	return nil
+}
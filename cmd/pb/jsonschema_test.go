@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestJSONSchema(t *testing.T) {
+	cmd := JSONSchemaCmd{
+		Protoset:    newFDS(t, "testdata/pbtest.pb"),
+		Out:         filepath.Join(t.TempDir(), "schema.json"),
+		MessageType: "Record",
+	}
+	require.NoError(t, cmd.Run())
+	requireJSONFilesEqual(t, "testdata/golden/TestJSONSchema.json", cmd.Out)
+}
+
+const oneofProto = `
+name: "oneof.proto"
+package: "test"
+syntax: "proto2"
+message_type: {
+  name: "Shape"
+  field: { name: "name" number: 1 label: LABEL_REQUIRED type: TYPE_STRING json_name: "name" }
+  field: { name: "side_len" number: 2 label: LABEL_OPTIONAL type: TYPE_DOUBLE oneof_index: 0 json_name: "sideLen" }
+  field: { name: "radius" number: 3 label: LABEL_OPTIONAL type: TYPE_UINT64 oneof_index: 0 json_name: "radius" }
+  oneof_decl: { name: "size" }
+}
+`
+
+func TestJSONSchemaOneof(t *testing.T) {
+	fdp := &descriptorpb.FileDescriptorProto{}
+	require.NoError(t, prototext.Unmarshal([]byte(oneofProto), fdp))
+	fd, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	require.NoError(t, err)
+
+	s := jsonSchema(fd.Messages().Get(0))
+	require.Equal(t, "#/$defs/test.Shape", s["$ref"])
+	shape := s["$defs"].(schema)["test.Shape"].(schema)
+	require.Equal(t, []interface{}{schema{"required": []string{"name"}}}, shape["allOf"])
+	require.Equal(t, schema{
+		"sideLen": schema{"not": schema{"anyOf": []interface{}{
+			schema{"required": []string{"side_len"}},
+			schema{"required": []string{"radius"}},
+		}}},
+		"side_len": schema{"not": schema{"anyOf": []interface{}{
+			schema{"required": []string{"sideLen"}},
+			schema{"required": []string{"radius"}},
+		}}},
+		"radius": schema{"not": schema{"anyOf": []interface{}{
+			schema{"required": []string{"sideLen"}},
+			schema{"required": []string{"side_len"}},
+		}}},
+	}, shape["dependentSchemas"])
+	properties := shape["properties"].(schema)
+	require.Equal(t, properties["sideLen"], properties["side_len"])
+	require.Equal(t, "uint64", properties["radius"].(schema)["anyOf"].([]schema)[1]["format"])
+}
+
+func TestJSONSchemaWellKnown(t *testing.T) {
+	s := jsonSchema((&structpb.Struct{}).ProtoReflect().Descriptor())
+	defs := s["$defs"].(schema)
+	require.Equal(t, schema{"type": "object", "additionalProperties": schema{"$ref": "#/$defs/google.protobuf.Value"}}, defs["google.protobuf.Struct"])
+	require.Equal(t, schema{}, defs["google.protobuf.Value"])
+	require.Len(t, defs, 2)
+}
+
+func TestJSONSchemaMatchesProtojson(t *testing.T) {
+	pb := &PBConfig{Protoset: newFDS(t, "testdata/pbtest.pb")}
+	require.NoError(t, pb.loadTypes())
+	tests := map[string]struct {
+		message string
+		doc     string
+		valid   bool
+	}{
+		"null fields":       {"Record", `{"id": null, "status": null, "items": null, "labels": null, "main": null}`, true},
+		"enum name":         {"Record", `{"status": "FAILED"}`, true},
+		"enum number":       {"Record", `{"status": 2}`, true},
+		"unknown enum":      {"Record", `{"status": 7}`, true},
+		"quoted int32":      {"Record", `{"items": [{"count": "5"}]}`, true},
+		"int32":             {"Record", `{"items": [{"count": -5}]}`, true},
+		"int64":             {"Record", `{"latencyMs": "12"}`, true},
+		"any":               {"Event", `{"detail": {"@type": "type.googleapis.com/pbtest.Event", "name": "e"}}`, true},
+		"bad enum name":     {"Record", `{"status": "NOPE"}`, false},
+		"int32 overflow":    {"Record", `{"items": [{"count": 2147483648}]}`, false},
+		"bad quoted int32":  {"Record", `{"items": [{"count": "x"}]}`, false},
+		"any without @type": {"Event", `{"detail": {"name": "e"}}`, false},
+		"unknown field":     {"Record", `{"nope": 1}`, false},
+		"null element":      {"Record", `{"tags": [null]}`, false},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			mt, err := lookupMessage(pb.types, tc.message)
+			require.NoError(t, err)
+			b, err := json.Marshal(jsonSchema(mt.Descriptor()))
+			require.NoError(t, err)
+			c := jsonschema.NewCompiler()
+			require.NoError(t, c.AddResource("schema.json", bytes.NewReader(b)))
+			sch, err := c.Compile("schema.json")
+			require.NoError(t, err)
+
+			var doc interface{}
+			require.NoError(t, json.Unmarshal([]byte(tc.doc), &doc))
+			schemaErr := sch.Validate(doc)
+			protojsonErr := protojson.UnmarshalOptions{Resolver: pb.types}.Unmarshal([]byte(tc.doc), mt.New().Interface())
+			if tc.valid {
+				require.NoError(t, schemaErr)
+				require.NoError(t, protojsonErr)
+			} else {
+				require.Error(t, schemaErr)
+				require.Error(t, protojsonErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// Errors for inputs exceeding the resource limits set with the --max-*
+// flags.
+var (
+	errInputTooLarge   = errors.New("input too large")
+	errTooDeep         = errors.New("messages nested too deeply")
+	errTooManyElements = errors.New("too many repeated field elements")
+	errTooLarge        = errors.New("decoded messages too large")
+)
+
+// Estimated memory sizes of decoded messages and values, used for the
+// --max-size limit.
+const (
+	messageSize = 64
+	scalarSize  = 8
+	stringSize  = 16
+)
+
+// readLimited reads all of r, failing if there are more than limit bytes.
+// A limit of 0 means no limit.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		return io.ReadAll(r)
+	}
+	b, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	return b, checkInputSize(len(b), limit)
+}
+
+func checkInputSize(n int, limit int64) error {
+	if limit > 0 && int64(n) > limit {
+		return fmt.Errorf("%w: more than %d bytes (--max-input-bytes)", errInputTooLarge, limit)
+	}
+	return nil
+}
+
+func readFileLimited(filename string, limit int64) ([]byte, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b, err := readLimited(f, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return b, nil
+}
+
+// decodeLimits checks decoded messages against the nesting depth,
+// repeated field and total size limits. The size of all messages checked
+// with the same decodeLimits is added up. Limits of 0 mean no limit.
+type decodeLimits struct {
+	maxDepth    int
+	maxRepeated int
+	maxSize     int64
+	size        int64
+}
+
+func (c *PBConfig) decodeLimits() *decodeLimits {
+	if c.MaxDepth <= 0 && c.MaxRepeated <= 0 && c.MaxSize <= 0 {
+		return nil
+	}
+	return &decodeLimits{maxDepth: c.MaxDepth, maxRepeated: c.MaxRepeated, maxSize: c.MaxSize}
+}
+
+// limitUnmarshaler returns unmarshal checking the decode limits. Binary
+// input is checked before it is unmarshaled, so that no memory is
+// allocated for input exceeding a limit. Text input is checked after it is
+// unmarshaled; the memory it takes up is bounded by --max-input-bytes.
+func (c *PBConfig) limitUnmarshaler(format string, types resolver, unmarshal unmarshaler) unmarshaler {
+	l := c.decodeLimits()
+	if l == nil {
+		return unmarshal
+	}
+	return func(b []byte, m proto.Message) error {
+		if format == "pb" {
+			if err := l.checkWire(b, m.ProtoReflect().Descriptor(), types, 1); err != nil {
+				return err
+			}
+			return unmarshal(b, m)
+		}
+		if err := unmarshal(b, m); err != nil {
+			return err
+		}
+		return l.checkMessage(m.ProtoReflect(), 1)
+	}
+}
+
+func (l *decodeLimits) addMessage(depth int) error {
+	if l.maxDepth > 0 && depth > l.maxDepth {
+		return fmt.Errorf("%w: more than %d levels (--max-depth)", errTooDeep, l.maxDepth)
+	}
+	return l.alloc(messageSize)
+}
+
+func (l *decodeLimits) alloc(n int) error {
+	l.size += int64(n)
+	if l.maxSize > 0 && l.size > l.maxSize {
+		return fmt.Errorf("%w: more than %d bytes (--max-size)", errTooLarge, l.maxSize)
+	}
+	return nil
+}
+
+func (l *decodeLimits) repeated(fd protoreflect.FieldDescriptor, n int) error {
+	if l.maxRepeated > 0 && n > l.maxRepeated {
+		return fmt.Errorf("%w: %s has more than %d elements (--max-repeated)", errTooManyElements, fd.FullName(), l.maxRepeated)
+	}
+	return nil
+}
+
+// checkWire checks the wire encoding b of a message described by md at
+// the given nesting depth. Malformed input is left to the unmarshaler to
+// report.
+func (l *decodeLimits) checkWire(b []byte, md protoreflect.MessageDescriptor, types protoregistry.ExtensionTypeResolver, depth int) error {
+	if err := l.addMessage(depth); err != nil {
+		return err
+	}
+	counts := map[protowire.Number]int{}
+	for len(b) > 0 {
+		num, typ, tagLen := protowire.ConsumeTag(b)
+		if tagLen < 0 {
+			return nil
+		}
+		valueLen := protowire.ConsumeFieldValue(num, typ, b[tagLen:])
+		if valueLen < 0 {
+			return nil
+		}
+		value := b[tagLen : tagLen+valueLen]
+		b = b[tagLen+valueLen:]
+
+		fd := fieldByNumber(md, num, types)
+		if fd == nil {
+			// unknown fields are kept as raw bytes
+			if err := l.alloc(tagLen + valueLen); err != nil {
+				return err
+			}
+			continue
+		}
+		elems := 1
+		if fd.IsList() && typ == protowire.BytesType && fd.Kind() != protoreflect.StringKind && fd.Kind() != protoreflect.BytesKind && fd.Message() == nil {
+			v, _ := protowire.ConsumeBytes(value)
+			elems = packedCount(fd.Kind(), v)
+		}
+		if fd.IsList() || fd.IsMap() {
+			counts[num] += elems
+			if err := l.repeated(fd, counts[num]); err != nil {
+				return err
+			}
+		}
+		var err error
+		switch {
+		case fd.IsMap():
+			v, _ := protowire.ConsumeBytes(value)
+			err = l.checkWire(v, fd.Message(), types, depth)
+		case fd.Message() != nil && typ == protowire.BytesType:
+			v, _ := protowire.ConsumeBytes(value)
+			err = l.checkWire(v, fd.Message(), types, depth+1)
+		case fd.Message() != nil && typ == protowire.StartGroupType:
+			v, _ := protowire.ConsumeGroup(num, value)
+			err = l.checkWire(v, fd.Message(), types, depth+1)
+		case fd.Kind() == protoreflect.StringKind || fd.Kind() == protoreflect.BytesKind:
+			err = l.alloc(stringSize + valueLen)
+		default:
+			err = l.alloc(elems * scalarSize)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// packedCount returns the number of elements in the packed encoding b of
+// a repeated field of the given scalar kind.
+func packedCount(kind protoreflect.Kind, b []byte) int {
+	switch kind {
+	case protoreflect.Fixed32Kind, protoreflect.Sfixed32Kind, protoreflect.FloatKind:
+		return len(b) / 4
+	case protoreflect.Fixed64Kind, protoreflect.Sfixed64Kind, protoreflect.DoubleKind:
+		return len(b) / 8
+	}
+	n := 0
+	for _, c := range b {
+		if c < 0x80 {
+			n++
+		}
+	}
+	return n
+}
+
+// checkMessage checks a decoded message at the given nesting depth.
+func (l *decodeLimits) checkMessage(m protoreflect.Message, depth int) error {
+	if err := l.addMessage(depth); err != nil {
+		return err
+	}
+	var err error
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsList():
+			list := v.List()
+			if err = l.repeated(fd, list.Len()); err != nil {
+				return false
+			}
+			for i := 0; i < list.Len() && err == nil; i++ {
+				err = l.checkValue(fd, list.Get(i), depth)
+			}
+		case fd.IsMap():
+			if err = l.repeated(fd, v.Map().Len()); err != nil {
+				return false
+			}
+			v.Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+				if err = l.checkValue(fd.MapKey(), k.Value(), depth); err == nil {
+					err = l.checkValue(fd.MapValue(), v, depth)
+				}
+				return err == nil
+			})
+		default:
+			err = l.checkValue(fd, v, depth)
+		}
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	return l.alloc(len(m.GetUnknown()))
+}
+
+func (l *decodeLimits) checkValue(fd protoreflect.FieldDescriptor, v protoreflect.Value, depth int) error {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return l.checkMessage(v.Message(), depth+1)
+	case protoreflect.StringKind:
+		return l.alloc(stringSize + len(v.String()))
+	case protoreflect.BytesKind:
+		return l.alloc(stringSize + len(v.Bytes()))
+	}
+	return l.alloc(scalarSize)
+}
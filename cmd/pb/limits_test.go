@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const nestedRecord = `{"id": "a", "tags": ["x", "y", "z"], "parent": {"parent": {"id": "c"}}}`
+
+func TestRunMaxInputBytes(t *testing.T) {
+	tmpDir := t.TempDir()
+	cli := PBConfig{
+		Protoset:      newFDS(t, "testdata/pbtest.pb"),
+		Out:           filepath.Join(tmpDir, "out.json"),
+		MessageType:   "Record",
+		In:            nestedRecord,
+		MaxInputBytes: int64(len(nestedRecord)),
+	}
+	require.NoError(t, cli.Run())
+	cli.MaxInputBytes--
+	require.ErrorIs(t, cli.Run(), errInputTooLarge)
+
+	in := filepath.Join(tmpDir, "in.json")
+	writeFile(t, in, nestedRecord)
+	cli.In = "@" + in
+	require.ErrorIs(t, cli.Run(), errInputTooLarge)
+
+	// decompressed input is limited too
+	b, err := compress([]byte(`{"id": "`+strings.Repeat("a", 1000)+`"}`), "gzip")
+	require.NoError(t, err)
+	gz := filepath.Join(tmpDir, "in.json.gz")
+	writeFile(t, gz, string(b))
+	cli.In = "@" + gz
+	cli.MaxInputBytes = 500
+	require.Less(t, len(b), 500)
+	err = cli.Run()
+	require.ErrorIs(t, err, errInputTooLarge)
+	require.Equal(t, "decompressed gzip input: input too large: more than 500 bytes (--max-input-bytes)", err.Error())
+}
+
+func TestRunDecodeLimits(t *testing.T) {
+	tmpDir := t.TempDir()
+	fds := newFDS(t, "testdata/pbtest.pb")
+	pbFile := filepath.Join(tmpDir, "in.pb")
+	cli := PBConfig{Protoset: fds, Out: pbFile, MessageType: "Record", In: nestedRecord}
+	require.NoError(t, cli.Run())
+
+	tests := map[string]struct {
+		cli     PBConfig
+		wantErr error
+	}{
+		"depth":    {PBConfig{MaxDepth: 3}, nil},
+		"depthErr": {PBConfig{MaxDepth: 2}, errTooDeep},
+		"repeated": {PBConfig{MaxRepeated: 3}, nil},
+		"repErr":   {PBConfig{MaxRepeated: 2}, errTooManyElements},
+		"size":     {PBConfig{MaxSize: 1000}, nil},
+		"sizeErr":  {PBConfig{MaxSize: 200}, errTooLarge},
+	}
+	for name, tc := range tests {
+		for _, in := range []string{nestedRecord, "@" + pbFile} {
+			tc := tc
+			tc.cli.Protoset = fds
+			tc.cli.Out = filepath.Join(tmpDir, "out.json")
+			tc.cli.MessageType = "Record"
+			tc.cli.In = in
+			err := tc.cli.Run()
+			if tc.wantErr == nil {
+				require.NoError(t, err, name, in)
+				requireJSONFileContent(t, nestedRecord, tc.cli.Out)
+			} else {
+				require.ErrorIs(t, err, tc.wantErr, name, in)
+			}
+		}
+	}
+}
+
+func TestRunDecodeLimitsStream(t *testing.T) {
+	cli := PBConfig{
+		Protoset:    newFDS(t, "testdata/pbtest.pb"),
+		Out:         filepath.Join(t.TempDir(), "out.jsonl"),
+		MessageType: "Record",
+		In:          "id,tags\na,x;y\nb,x;y;z\n",
+		InFormat:    "csv",
+		MaxRepeated: 2,
+	}
+	err := cli.Run()
+	require.ErrorIs(t, err, errTooManyElements)
+	require.Equal(t, "row 2: too many repeated field elements: pbtest.Record.tags has more than 2 elements (--max-repeated)", err.Error())
+
+	// the size of all messages of a stream is added up
+	cli.In = strings.Repeat(`{"id": "a"}`+"\n", 10)
+	cli.InFormat = "jsonl"
+	cli.MaxRepeated = 0
+	cli.MaxSize = 10 * (messageSize + stringSize + 1)
+	require.NoError(t, cli.Run())
+	cli.MaxSize--
+	require.ErrorIs(t, cli.Run(), errTooLarge)
+}
+
+func TestPackedCount(t *testing.T) {
+	require.Equal(t, 3, packedCount(protoreflect.Int64Kind, []byte{0x01, 0x80, 0x01, 0xff, 0xff, 0x7f}))
+	require.Equal(t, 2, packedCount(protoreflect.FloatKind, make([]byte, 8)))
+	require.Equal(t, 2, packedCount(protoreflect.DoubleKind, make([]byte, 16)))
+}
+
+func TestReadLimited(t *testing.T) {
+	b, err := readLimited(bytes.NewReader([]byte("abc")), 3)
+	require.NoError(t, err)
+	require.Equal(t, "abc", string(b))
+	_, err = readLimited(bytes.NewReader([]byte("abcd")), 3)
+	require.ErrorIs(t, err, errInputTooLarge)
+	_, err = readFileLimited("testdata/missing.json", 3)
+	require.Error(t, err)
+}
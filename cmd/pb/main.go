@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -13,6 +12,7 @@ import (
 	"golang.org/x/sys/unix"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/reflect/protoregistry"
@@ -40,29 +40,54 @@ var (
 pb translates encoded Protobuf message from one format to another
 `
 	cli struct {
-		PBConfig
-		Version kong.VersionFlag `help:"Show version."`
+		Convert            PBConfig         `cmd:"" default:"withargs" help:"Translate a message from one format to another (default)."`
+		Size               SizeCmd          `cmd:"" help:"Report the encoded size of every field of a message."`
+		Merge              MergeCmd         `cmd:"" help:"Merge several messages into one."`
+		ExtractDescriptors ExtractCmd       `cmd:"" help:"Extract the file descriptors embedded in a Go binary."`
+		JSONSchema         JSONSchemaCmd    `cmd:"" name:"jsonschema" help:"Write a JSON Schema of the JSON encoding of a message."`
+		Deps               DepsCmd          `cmd:"" help:"List the types a type depends on, or depending on it."`
+		Serve              ServeCmd         `cmd:"" help:"Serve message conversions over HTTP."`
+		Repl               ReplCmd          `cmd:"" help:"Explore and build messages interactively."`
+		Version            kong.VersionFlag `help:"Show version."`
 	}
 )
 
 type PBConfig struct {
 	Protoset *descriptorpb.FileDescriptorSet `short:"P" help:"Protoset containing Message to be translated"`
 
-	Out         string `short:"o" help:"Output file name"`
-	InFormat    string `short:"I" help:"Input format (j[son], p[b], t[xt])" enum:"json,pb,txt,j,p,t," default:""`
-	OutFormat   string `short:"O" help:"Output format (j[son], p[b], t[xt])" enum:"json,pb,txt,j,p,t," default:""`
-	Zero        bool   `short:"z" help:"Print zero values in JSON output"`
-	MessageType string `arg:"" help:"Message type to be translated"`
-	In          string `arg:"" help:"Message value JSON encoded" optional:""`
+	Out            string   `short:"o" help:"Output file name"`
+	InFormat       string   `short:"I" help:"Input format (j[son], p[b], t[xt], jsonl, pbd, csv, tsv, grpc, grpc-web-text)" enum:"json,pb,txt,jsonl,pbd,csv,tsv,grpc,grpc-web-text,j,p,t," default:""`
+	OutFormat      string   `short:"O" help:"Output format (j[son], p[b], t[xt], jsonl, pbd, csv, tsv, grpc, grpc-web-text)" enum:"json,pb,txt,jsonl,pbd,csv,tsv,grpc,grpc-web-text,j,p,t," default:""`
+	Zero           bool     `short:"z" help:"Print zero values in JSON output"`
+	InCompression  string   `help:"Input compression (gzip, zstd, snappy, none), detected by default" enum:"gzip,zstd,snappy,none," default:""`
+	OutCompression string   `help:"Output compression (gzip, zstd, snappy, none), from the output file extension by default" enum:"gzip,zstd,snappy,none," default:""`
+	OutDir         string   `short:"d" help:"Output directory for batch conversion of @file inputs"`
+	Jobs           int      `short:"j" help:"Number of parallel batch conversions (default: number of CPUs)"`
+	Columns        []string `help:"Dotted field paths of the CSV/TSV columns, in order"`
+	Explode        bool     `help:"Write a CSV/TSV row per repeated field element instead of joining them"`
+	GRPCEncoding   string   `name:"grpc-encoding" help:"Compression of compressed gRPC message frames (gzip, zstd, snappy)" enum:"gzip,zstd,snappy," default:""`
+	GRPCCompress   bool     `name:"grpc-compress" help:"Compress gRPC message frames in grpc and grpc-web-text output"`
+	Pretty         bool     `help:"Show timestamps, durations and Struct values in a readable form in txt output, and accept them in txt input"`
+	MaxInputBytes  int64    `help:"Maximum number of bytes of an input, also after decompression (0: no limit)"`
+	MaxDepth       int      `help:"Maximum nesting depth of decoded messages (0: no limit)"`
+	MaxRepeated    int      `help:"Maximum number of elements of a decoded repeated or map field (0: no limit)"`
+	MaxSize        int64    `help:"Maximum estimated memory size of the decoded messages of an input (0: no limit)"`
+	Color          string   `help:"Colorize json and txt output on a terminal (auto, always, never)" enum:"auto,always,never," default:"auto"`
+	Where          string   `short:"w" help:"Keep only messages matching the predicate expression, e.g. 'status == \"FAILED\" && latency_ms > 500'"`
+	MessageType    string   `arg:"" help:"Message type to be translated"`
+	In             string   `arg:"" help:"Message value JSON encoded" optional:""`
+	Inputs         []string `arg:"" help:"Further @file inputs or @glob patterns for batch conversion" optional:""`
 
-	types *protoregistry.Types
+	types  *protoregistry.Types
+	pretty *prettyOnce // pretty text of types, built on first use
+	where  predicate
 }
 
 func main() {
 	kctx := kong.Parse(&cli,
 		kong.Description(description),
 		kong.Vars{"version": fmt.Sprintf("%s (%s on %s)", version, commit, date)},
-		kong.TypeMapper(reflect.TypeOf(cli.PBConfig.Protoset), kong.MapperFunc(fdsMapper)),
+		kong.TypeMapper(reflect.TypeOf(cli.Convert.Protoset), kong.MapperFunc(fdsMapper)),
 	)
 	kctx.FatalIfErrorf(kctx.Run())
 }
@@ -70,93 +95,169 @@ func main() {
 type unmarshaler func([]byte, proto.Message) error
 type marshaler func(proto.Message) ([]byte, error)
 
-func (c *PBConfig) Run() error {
-	c.types = registry.CloneTypes(protoregistry.GlobalTypes)
-	if c.Protoset != nil {
-		if err := registry.AddDynamicTypes(c.types, c.Protoset); err != nil {
-			return err
-		}
-	}
+// resolver is the union of the resolver interfaces needed by the
+// protojson, prototext and proto marshaling options.
+type resolver interface {
+	protoregistry.MessageTypeResolver
+	protoregistry.ExtensionTypeResolver
+}
 
-	mt, err := lookupMessage(c.types, c.MessageType)
+func (c *PBConfig) Run() error {
+	mt, err := c.messageType()
 	if err != nil {
 		return err
 	}
+	if c.where, err = compileWhere(mt.Descriptor(), c.Where); err != nil {
+		return err
+	}
+	if c.isBatch() {
+		return c.runBatch(mt)
+	}
 	in, err := c.readInput()
 	if err != nil {
 		return err
 	}
-	unmarshal, err := c.unmarshaler()
+	b, err := c.convert(mt, in, c.inFormat())
+	if err != nil {
+		return err
+	}
+	return c.writeOutput(b)
+}
+
+// messageType builds the type registry from the global types and the
+// protoset and looks up the message type to translate in it.
+func (c *PBConfig) messageType() (protoreflect.MessageType, error) {
+	if err := c.loadTypes(); err != nil {
+		return nil, err
+	}
+	return lookupMessage(c.types, c.MessageType)
+}
+
+func (c *PBConfig) loadTypes() error {
+	c.types = registry.CloneTypes(protoregistry.GlobalTypes)
+	c.pretty = &prettyOnce{}
+	if c.Protoset != nil {
+		return registry.AddDynamicTypes(c.types, c.Protoset)
+	}
+	return nil
+}
+
+// convert decodes in as a message of type mt in the given input format and
+// returns it encoded in the output format.
+func (c *PBConfig) convert(mt protoreflect.MessageType, in []byte, inFormat string) ([]byte, error) {
+	if isStream(inFormat) || isStream(c.outFormat()) || c.where != nil {
+		return c.convertStream(mt, in, inFormat)
+	}
+	var types resolver = c.types
+	unmarshal, err := c.unmarshaler(inFormat, types)
 	if err != nil {
-		return fmt.Errorf("cannot decode %q input: %w", c.inFormat(), err)
+		return nil, fmt.Errorf("cannot decode %q input: %w", inFormat, err)
 	}
 	message := mt.New().Interface()
 	if err := unmarshal(in, message); err != nil {
-		return err
+		return nil, err
 	}
 	if fds, ok := message.(*descriptorpb.FileDescriptorSet); ok {
-		if err := registry.AddDynamicTypes(c.types, fds); err != nil {
-			return err
+		// Add the input to a copy of the registry so that concurrent
+		// batch conversions do not see each other's types.
+		fdsTypes := registry.CloneTypes(c.types)
+		if err := registry.AddDynamicTypes(fdsTypes, fds); err != nil {
+			return nil, err
 		}
+		types = fdsTypes
 		// Unmarshal again with the input in the resolver registry so
 		// that any exensions defined and used in the input are
 		// unmarshaled properly.
+		if unmarshal, err = c.unmarshaler(inFormat, types); err != nil {
+			return nil, err
+		}
 		if err := unmarshal(in, message); err != nil {
-			return err
+			return nil, err
 		}
 	}
-	marshal, err := c.marshaler()
-	if err != nil {
-		return err
-	}
-	b, err := marshal(message)
+	marshal, err := c.marshaler(types)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return c.writeOutput(b)
+	return marshal(message)
 }
 
 func (c *PBConfig) AfterApply() error {
-	if c.Zero && c.outFormat() != "json" {
-		return fmt.Errorf(`cannot print zero values with %q, only "json"`, c.outFormat())
+	if c.Zero && c.outFormat() != "json" && c.outFormat() != "jsonl" {
+		return fmt.Errorf(`cannot print zero values with %q, only "json" and "jsonl"`, c.outFormat())
 	}
-	return nil
+	return c.validateBatch()
 }
 
 func (c *PBConfig) readInput() ([]byte, error) {
 	if c.In == "" {
-		return io.ReadAll(os.Stdin)
+		b, err := readLimited(os.Stdin, c.MaxInputBytes)
+		if err != nil {
+			return nil, err
+		}
+		return decompress(b, c.inCompression("", b), c.MaxInputBytes)
 	}
 	if strings.HasPrefix(c.In, "@") {
-		return os.ReadFile(c.In[1:])
+		return c.readFile(c.In[1:])
 	}
-	return []byte(c.In), nil
+	return []byte(c.In), checkInputSize(len(c.In), c.MaxInputBytes)
+}
+
+// readFile reads a file, decompressing it if it is compressed.
+func (c *PBConfig) readFile(filename string) ([]byte, error) {
+	b, err := readFileLimited(filename, c.MaxInputBytes)
+	if err != nil {
+		return nil, err
+	}
+	return decompress(b, c.inCompression(filename, b), c.MaxInputBytes)
 }
 
 func (c *PBConfig) writeOutput(b []byte) error {
+	compression := c.outCompression(c.Out)
+	b, err := compress(b, compression)
+	if err != nil {
+		return err
+	}
 	if c.Out == "" {
-		if getFormat("", c.OutFormat) == "pb" && isTTY() {
+		format, tty := getFormat("", c.OutFormat), isTTY()
+		if (isBinary(format) || compression != "none") && tty {
 			return fmt.Errorf("not writing binary to terminal. Use -O json or -O txt to output a textual format")
 		}
+		if compression == "none" && useColor(c.Color, tty) {
+			b = highlight(b, format)
+		}
+		if tty {
+			return page(os.Stdout, b, terminalHeight())
+		}
 		_, err := os.Stdout.Write(b)
 		return err
 	}
 	return os.WriteFile(c.Out, b, 0666)
 }
 
-func (c *PBConfig) unmarshaler() (unmarshaler, error) {
-	switch c.inFormat() {
+func (c *PBConfig) unmarshaler(format string, types resolver) (unmarshaler, error) {
+	var unmarshal unmarshaler
+	switch format {
 	case "json":
-		o := protojson.UnmarshalOptions{Resolver: c.types}
-		return o.Unmarshal, nil
+		o := protojson.UnmarshalOptions{Resolver: types}
+		unmarshal = o.Unmarshal
 	case "pb":
-		o := proto.UnmarshalOptions{Resolver: c.types}
-		return o.Unmarshal, nil
+		o := proto.UnmarshalOptions{Resolver: types}
+		unmarshal = o.Unmarshal
 	case "txt":
-		o := prototext.UnmarshalOptions{Resolver: c.types}
-		return o.Unmarshal, nil
+		o := prototext.UnmarshalOptions{Resolver: types}
+		unmarshal = o.Unmarshal
+		if c.Pretty {
+			p, err := c.prettyText(types)
+			if err != nil {
+				return nil, err
+			}
+			unmarshal = p.unmarshaler(o)
+		}
+	default:
+		return nil, fmt.Errorf("unknown input format %q", format)
 	}
-	return nil, fmt.Errorf("unknown input format %q", c.inFormat())
+	return c.limitUnmarshaler(format, types, unmarshal), nil
 }
 
 func (c *PBConfig) inFormat() string {
@@ -167,11 +268,11 @@ func (c *PBConfig) outFormat() string {
 	return getFormat("@"+c.Out, c.OutFormat)
 }
 
-func (c *PBConfig) marshaler() (marshaler, error) {
+func (c *PBConfig) marshaler(types resolver) (marshaler, error) {
 	switch c.outFormat() {
 	case "json":
 		o := protojson.MarshalOptions{
-			Resolver:        c.types,
+			Resolver:        types,
 			Multiline:       true,
 			EmitUnpopulated: c.Zero,
 		}
@@ -182,11 +283,48 @@ func (c *PBConfig) marshaler() (marshaler, error) {
 			}
 			return append(b, byte('\n')), nil
 		}, nil
+	case "jsonl":
+		o := protojson.MarshalOptions{
+			Resolver:        types,
+			EmitUnpopulated: c.Zero,
+		}
+		return func(m proto.Message) ([]byte, error) {
+			b, err := o.Marshal(m)
+			if err != nil {
+				return nil, err
+			}
+			return append(b, byte('\n')), nil
+		}, nil
 	case "pb":
 		o := proto.MarshalOptions{}
 		return o.Marshal, nil
+	case "pbd":
+		o := proto.MarshalOptions{}
+		return func(m proto.Message) ([]byte, error) {
+			b, err := o.Marshal(m)
+			if err != nil {
+				return nil, err
+			}
+			return append(protowire.AppendVarint(nil, uint64(len(b))), b...), nil
+		}, nil
+	case "grpc", "grpc-web-text":
+		o := proto.MarshalOptions{}
+		return func(m proto.Message) ([]byte, error) {
+			b, err := o.Marshal(m)
+			if err != nil {
+				return nil, err
+			}
+			return c.appendFrame(nil, b)
+		}, nil
 	case "txt":
-		o := prototext.MarshalOptions{Resolver: c.types, Multiline: true}
+		o := prototext.MarshalOptions{Resolver: types, Multiline: true}
+		if c.Pretty {
+			p, err := c.prettyText(types)
+			if err != nil {
+				return nil, err
+			}
+			return p.marshaler(o), nil
+		}
 		return o.Marshal, nil
 	}
 	return nil, fmt.Errorf("unknown output format %s", c.outFormat())
@@ -196,7 +334,7 @@ func getFormat(contentOrFile string, format string) string {
 	if format != "" {
 		return canonicalFormat(format)
 	}
-	ext := filepath.Ext(contentOrFile)
+	ext := filepath.Ext(trimCompressionExt(contentOrFile))
 	// default to JSON for stdout, string input and files without extension
 	if contentOrFile == "@" || !strings.HasPrefix(contentOrFile, "@") || ext == "" {
 		return "json"
@@ -210,8 +348,10 @@ func canonicalFormat(format string) string {
 		return "json"
 	case "pb", "p":
 		return "pb"
-	case "txt", "t", "prototext", "prototxt":
+	case "txt", "t", "prototext", "prototxt", "txtpb", "textproto":
 		return "txt"
+	case "jsonl", "ndjson":
+		return "jsonl"
 	}
 	return format
 }
@@ -259,7 +399,42 @@ func fdsMapper(kctx *kong.DecodeContext, target reflect.Value) error {
 	return proto.Unmarshal(b, fds)
 }
 
+func readProtoset(filename string) (*descriptorpb.FileDescriptorSet, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	fds := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(b, fds); err != nil {
+		return nil, fmt.Errorf("cannot decode protoset %s: %w", filename, err)
+	}
+	return fds, nil
+}
+
 func isTTY() bool {
 	_, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ)
 	return err == nil
 }
+
+// terminalHeight returns the number of rows of the terminal on stdout, or
+// 0 if stdout is not a terminal.
+func terminalHeight() int {
+	ws, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return 0
+	}
+	return int(ws.Row)
+}
+
+// useColor returns whether output is colorized for the given --color mode.
+// In "auto" mode, the default, output is colorized on a terminal unless
+// the NO_COLOR environment variable is set.
+func useColor(mode string, tty bool) bool {
+	switch mode {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	return tty && os.Getenv("NO_COLOR") == ""
+}
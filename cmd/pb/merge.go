@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+type MergeCmd struct {
+	Protoset        *descriptorpb.FileDescriptorSet `short:"P" help:"Protoset containing Message to be merged"`
+	Out             string                          `short:"o" help:"Output file name"`
+	InFormat        string                          `short:"I" help:"Input format of all inputs (j[son], p[b], t[xt], jsonl, pbd, csv, tsv, grpc, grpc-web-text), from each file extension by default" enum:"json,pb,txt,jsonl,pbd,csv,tsv,grpc,grpc-web-text,j,p,t," default:""`
+	OutFormat       string                          `short:"O" help:"Output format (j[son], p[b], t[xt], jsonl, pbd, csv, tsv, grpc, grpc-web-text)" enum:"json,pb,txt,jsonl,pbd,csv,tsv,grpc,grpc-web-text,j,p,t," default:""`
+	Zero            bool                            `short:"z" help:"Print zero values in JSON output"`
+	InCompression   string                          `help:"Input compression of all inputs (gzip, zstd, snappy, none), detected by default" enum:"gzip,zstd,snappy,none," default:""`
+	OutCompression  string                          `help:"Output compression (gzip, zstd, snappy, none), from the output file extension by default" enum:"gzip,zstd,snappy,none," default:""`
+	GRPCEncoding    string                          `name:"grpc-encoding" help:"Compression of compressed gRPC message frames (gzip, zstd, snappy)" enum:"gzip,zstd,snappy," default:""`
+	GRPCCompress    bool                            `name:"grpc-compress" help:"Compress gRPC message frames in grpc and grpc-web-text output"`
+	Pretty          bool                            `help:"Show timestamps, durations and Struct values in a readable form in txt output, and accept them in txt input"`
+	MaxInputBytes   int64                           `help:"Maximum number of bytes of an input, also after decompression (0: no limit)"`
+	MaxDepth        int                             `help:"Maximum nesting depth of decoded messages (0: no limit)"`
+	MaxRepeated     int                             `help:"Maximum number of elements of a decoded repeated or map field (0: no limit)"`
+	MaxSize         int64                           `help:"Maximum estimated memory size of the decoded messages of an input (0: no limit)"`
+	Color           string                          `help:"Colorize json and txt output on a terminal (auto, always, never)" enum:"auto,always,never," default:"auto"`
+	ReplaceRepeated bool                            `short:"r" help:"Replace repeated fields instead of appending to them"`
+	Mask            []string                        `short:"m" help:"Dotted field paths replaced by each later input, leaving all other fields unchanged"`
+	MessageType     string                          `arg:"" help:"Message type to be merged"`
+	Inputs          []string                        `arg:"" help:"Message values or @files to merge in order"`
+}
+
+func (c *MergeCmd) AfterApply() error {
+	return c.config().AfterApply()
+}
+
+func (c *MergeCmd) config() *PBConfig {
+	return &PBConfig{
+		Protoset:       c.Protoset,
+		Out:            c.Out,
+		InFormat:       c.InFormat,
+		OutFormat:      c.OutFormat,
+		Zero:           c.Zero,
+		InCompression:  c.InCompression,
+		OutCompression: c.OutCompression,
+		GRPCEncoding:   c.GRPCEncoding,
+		GRPCCompress:   c.GRPCCompress,
+		Pretty:         c.Pretty,
+		MaxInputBytes:  c.MaxInputBytes,
+		MaxDepth:       c.MaxDepth,
+		MaxRepeated:    c.MaxRepeated,
+		MaxSize:        c.MaxSize,
+		Color:          c.Color,
+		MessageType:    c.MessageType,
+	}
+}
+
+// Run decodes every input in its own format and merges them in order into
+// a single message with proto.Merge semantics: later scalar fields
+// override earlier ones, messages are merged recursively and repeated
+// fields are appended. Every message of a stream input is merged.
+func (c *MergeCmd) Run() error {
+	pb := c.config()
+	mt, err := pb.messageType()
+	if err != nil {
+		return err
+	}
+	mask, err := parseMask(mt.Descriptor(), c.Mask)
+	if err != nil {
+		return err
+	}
+	merged := mt.New().Interface()
+	first := true
+	for _, input := range c.Inputs {
+		pb.In = input
+		in, err := pb.readInput()
+		if err != nil {
+			return err
+		}
+		messages, err := pb.decodeStream(mt, in, pb.inFormat())
+		if err != nil {
+			return fmt.Errorf("%s: %w", input, err)
+		}
+		for _, m := range messages {
+			switch {
+			case first || len(mask) == 0:
+				mergeMessage(merged, m, c.ReplaceRepeated)
+			default:
+				for _, path := range mask {
+					replacePath(merged.ProtoReflect(), m.ProtoReflect(), path)
+				}
+			}
+			first = false
+		}
+	}
+	b, err := pb.encodeStream(mt, []proto.Message{merged})
+	if err != nil {
+		return err
+	}
+	return pb.writeOutput(b)
+}
+
+// mergeMessage merges src into dst with proto.Merge. If replaceRepeated is
+// set, repeated fields of dst that are populated in src are cleared first
+// so that they are replaced rather than appended to.
+func mergeMessage(dst, src proto.Message, replaceRepeated bool) {
+	if replaceRepeated {
+		clearReplacedLists(dst.ProtoReflect(), src.ProtoReflect())
+	}
+	proto.Merge(dst, src)
+}
+
+func clearReplacedLists(dst, src protoreflect.Message) {
+	src.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsList():
+			dst.Clear(fd)
+		case fd.Message() != nil && !fd.IsMap() && dst.Has(fd):
+			clearReplacedLists(dst.Mutable(fd).Message(), v.Message())
+		}
+		return true
+	})
+}
+
+// replacePath replaces the field at the end of path in dst with the field
+// in src, clearing it in dst if it is not set in src.
+func replacePath(dst, src protoreflect.Message, path []protoreflect.FieldDescriptor) {
+	fd := path[0]
+	if len(path) > 1 {
+		if src.Has(fd) || dst.Has(fd) {
+			replacePath(dst.Mutable(fd).Message(), src.Get(fd).Message(), path[1:])
+		}
+		return
+	}
+	dst.Clear(fd)
+	if src.Has(fd) {
+		// Merge the field on its own into dst to deep copy it.
+		part := src.Type().New()
+		part.Set(fd, src.Get(fd))
+		proto.Merge(dst.Interface(), part.Interface())
+	}
+}
+
+// parseMask resolves the dotted field paths of a field mask. Fields are
+// named by their proto or JSON names and all but the last field of a path
+// must be singular message fields.
+func parseMask(md protoreflect.MessageDescriptor, paths []string) ([][]protoreflect.FieldDescriptor, error) {
+	result := make([][]protoreflect.FieldDescriptor, len(paths))
+	for i, path := range paths {
+		pathMD := md
+		for _, name := range strings.Split(path, ".") {
+			if pathMD == nil {
+				return nil, fmt.Errorf("invalid mask path %s: %s is not a singular message field", path, columnName(result[i]))
+			}
+			fd := pathMD.Fields().ByName(protoreflect.Name(name))
+			if fd == nil {
+				fd = pathMD.Fields().ByJSONName(name)
+			}
+			if fd == nil {
+				return nil, fmt.Errorf("invalid mask path %s: no field %s in %s", path, name, pathMD.FullName())
+			}
+			result[i] = append(result[i], fd)
+			pathMD = nil
+			if fd.Message() != nil && !fd.IsList() && !fd.IsMap() {
+				pathMD = fd.Message()
+			}
+		}
+	}
+	return result, nil
+}
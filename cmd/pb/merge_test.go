@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerge(t *testing.T) {
+	tmpDir := t.TempDir()
+	fds := newFDS(t, "testdata/pbtest.pb")
+	base := filepath.Join(tmpDir, "base.json")
+	env := filepath.Join(tmpDir, "env.txtpb")
+	override := filepath.Join(tmpDir, "override.pb")
+	writeFile(t, base, `{"id": "base", "tags": ["a"], "main": {"name": "m", "count": 1}, "labels": {"x": "1", "y": "2"}}`)
+	writeFile(t, env, `tags: "b" main: {count: 2} labels: {key: "y" value: "3"}`)
+	cli := PBConfig{
+		Protoset:    fds,
+		Out:         override,
+		MessageType: "Record",
+		In:          `{"status": "OK", "tags": ["c"]}`,
+	}
+	require.NoError(t, cli.Run())
+	inputs := []string{"@" + base, "@" + env, "@" + override}
+
+	tests := map[string]struct {
+		replaceRepeated bool
+		mask            []string
+		want            string
+	}{
+		"merge": {
+			want: `{"id": "base", "status": "OK", "tags": ["a", "b", "c"], "main": {"name": "m", "count": 2}, "labels": {"x": "1", "y": "3"}}`,
+		},
+		"replace repeated": {
+			replaceRepeated: true,
+			want:            `{"id": "base", "status": "OK", "tags": ["c"], "main": {"name": "m", "count": 2}, "labels": {"x": "1", "y": "3"}}`,
+		},
+		"mask": {
+			mask: []string{"tags", "main.name", "labels"},
+			want: `{"id": "base", "tags": ["c"], "main": {"count": 1}}`,
+		},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			cmd := MergeCmd{
+				Protoset:        fds,
+				Out:             filepath.Join(tmpDir, "out.json"),
+				ReplaceRepeated: tc.replaceRepeated,
+				Mask:            tc.mask,
+				MessageType:     "Record",
+				Inputs:          inputs,
+			}
+			require.NoError(t, cmd.Run())
+			requireJSONFileContent(t, tc.want, cmd.Out)
+		})
+	}
+}
+
+func TestMergeStream(t *testing.T) {
+	tmpDir := t.TempDir()
+	cmd := MergeCmd{
+		Protoset:    newFDS(t, "testdata/pbtest.pb"),
+		Out:         filepath.Join(tmpDir, "out.txt"),
+		InFormat:    "jsonl",
+		MessageType: "Record",
+		Inputs:      []string{`{"id": "a"}` + "\n" + `{"tags": ["x"]}`, `{"id": "b"}`},
+	}
+	require.NoError(t, cmd.Run())
+	cmd = MergeCmd{
+		Protoset:    cmd.Protoset,
+		Out:         filepath.Join(tmpDir, "out.json"),
+		MessageType: "Record",
+		Inputs:      []string{"@" + cmd.Out},
+	}
+	require.NoError(t, cmd.Run())
+	requireJSONFileContent(t, `{"id": "b", "tags": ["x"]}`, cmd.Out)
+}
+
+func TestMergeOptions(t *testing.T) {
+	tmpDir := t.TempDir()
+	fds := newFDS(t, "testdata/pbtest.pb")
+	cmd := MergeCmd{
+		Protoset:       fds,
+		Out:            filepath.Join(tmpDir, "out.pb"),
+		OutCompression: "gzip",
+		MessageType:    "Event",
+		Inputs:         []string{`{"name": "a"}`, `{"elapsed": "1.5s"}`},
+	}
+	require.NoError(t, cmd.Run())
+	cmd = MergeCmd{
+		Protoset:      fds,
+		Out:           filepath.Join(tmpDir, "out.txt"),
+		InCompression: "gzip",
+		Pretty:        true,
+		MessageType:   "Event",
+		Inputs:        []string{"@" + cmd.Out},
+	}
+	require.NoError(t, cmd.Run())
+	b, err := os.ReadFile(cmd.Out)
+	require.NoError(t, err)
+	require.Contains(t, string(b), `"1.5s"`)
+
+	cmd = MergeCmd{
+		Protoset:    fds,
+		Out:         filepath.Join(tmpDir, "out.json"),
+		MaxDepth:    1,
+		MessageType: "Record",
+		Inputs:      []string{`{"id": "a"}`, `{"parent": {"main": {}}}`},
+	}
+	err = cmd.Run()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "--max-depth")
+}
+
+func TestMergeErr(t *testing.T) {
+	fds := newFDS(t, "testdata/pbtest.pb")
+	tests := map[string]MergeCmd{
+		"unknown message":   {MessageType: "Nope", Inputs: []string{"{}"}},
+		"bad input":         {MessageType: "Record", Inputs: []string{"{}", `{"nope": 1}`}},
+		"missing file":      {MessageType: "Record", Inputs: []string{"@nonexistent.json"}},
+		"unknown mask path": {MessageType: "Record", Inputs: []string{"{}"}, Mask: []string{"nope"}},
+		"repeated in path":  {MessageType: "Record", Inputs: []string{"{}"}, Mask: []string{"items.name"}},
+	}
+	for name, cmd := range tests {
+		cmd := cmd
+		t.Run(name, func(t *testing.T) {
+			cmd.Protoset = fds
+			cmd.Out = filepath.Join(t.TempDir(), "out.json")
+			require.Error(t, cmd.Run())
+		})
+	}
+	cmd := MergeCmd{OutFormat: "txt", Zero: true}
+	require.Error(t, cmd.AfterApply())
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"foxygo.at/protog/registry"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// prettyTypes are the well-known types shown as a string in pretty text:
+// timestamps in RFC 3339, durations like "1.5s" and the Struct types as
+// JSON.
+var prettyTypes = map[protoreflect.FullName]bool{
+	"google.protobuf.Timestamp": true,
+	"google.protobuf.Duration":  true,
+	"google.protobuf.Struct":    true,
+	"google.protobuf.Value":     true,
+	"google.protobuf.ListValue": true,
+}
+
+// messageRanger is a resolver that can list all its message types.
+type messageRanger interface {
+	resolver
+	RangeMessages(func(protoreflect.MessageType) bool)
+}
+
+// prettyText converts messages to and from the pretty text format. Every
+// message type is mirrored by a derived type in which the fields of the
+// prettyTypes are replaced by string fields, so that prototext can be
+// used on the derived messages as is. Messages packed in Any fields are
+// converted too.
+type prettyText struct {
+	types  resolver
+	pretty *registry.Files
+}
+
+func newPrettyText(types messageRanger) (*prettyText, error) {
+	files := map[string]protoreflect.FileDescriptor{}
+	types.RangeMessages(func(mt protoreflect.MessageType) bool {
+		addFileDeps(files, mt.Descriptor().ParentFile())
+		return true
+	})
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	fds := &descriptorpb.FileDescriptorSet{}
+	for _, path := range paths {
+		fdp := protodesc.ToFileDescriptorProto(files[path])
+		if fdp.GetPackage() != "google.protobuf" {
+			prettyFields(fdp.MessageType, fdp.Extension)
+		}
+		fds.File = append(fds.File, fdp)
+	}
+	pretty, err := registry.NewFiles(fds)
+	if err != nil {
+		return nil, fmt.Errorf("cannot derive pretty text types: %w", err)
+	}
+	return &prettyText{types: types, pretty: pretty}, nil
+}
+
+func addFileDeps(files map[string]protoreflect.FileDescriptor, fd protoreflect.FileDescriptor) {
+	if _, ok := files[fd.Path()]; ok {
+		return
+	}
+	files[fd.Path()] = fd
+	imports := fd.Imports()
+	for i := 0; i < imports.Len(); i++ {
+		addFileDeps(files, imports.Get(i).FileDescriptor)
+	}
+}
+
+// prettyFields replaces the fields of the prettyTypes with string fields
+// in the given messages, their nested messages and the extensions.
+func prettyFields(messages []*descriptorpb.DescriptorProto, extensions []*descriptorpb.FieldDescriptorProto) {
+	for _, field := range extensions {
+		if prettyTypes[protoreflect.FullName(strings.TrimPrefix(field.GetTypeName(), "."))] {
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()
+			field.TypeName = nil
+		}
+	}
+	for _, m := range messages {
+		prettyFields(m.NestedType, append(m.Field, m.Extension...))
+	}
+}
+
+func (p *prettyText) marshaler(o prototext.MarshalOptions) marshaler {
+	o.Resolver = p.pretty
+	return func(m proto.Message) ([]byte, error) {
+		pm, err := p.convert(m.ProtoReflect(), p.pretty)
+		if err != nil {
+			return nil, err
+		}
+		return o.Marshal(pm.Interface())
+	}
+}
+
+func (p *prettyText) unmarshaler(o prototext.UnmarshalOptions) unmarshaler {
+	o.Resolver = p.pretty
+	return func(b []byte, m proto.Message) error {
+		mt, err := p.pretty.FindMessageByName(m.ProtoReflect().Descriptor().FullName())
+		if err != nil {
+			return err
+		}
+		pm := mt.New()
+		if err := o.Unmarshal(b, pm.Interface()); err != nil {
+			return err
+		}
+		proto.Reset(m)
+		return p.copyMessage(m.ProtoReflect(), pm, p.types)
+	}
+}
+
+// convert returns a copy of m as a message of the type with the same name
+// in types.
+func (p *prettyText) convert(m protoreflect.Message, types resolver) (protoreflect.Message, error) {
+	mt, err := types.FindMessageByName(m.Descriptor().FullName())
+	if err != nil {
+		return nil, err
+	}
+	dst := mt.New()
+	return dst, p.copyMessage(dst, m, types)
+}
+
+// copyMessage copies the fields of src to dst, which are mirrored types,
+// converting the values of pretty fields between strings and messages.
+// types resolves the extensions and Any contents of dst.
+func (p *prettyText) copyMessage(dst, src protoreflect.Message, types resolver) error {
+	if src.Descriptor().FullName() == "google.protobuf.Any" {
+		return p.copyAny(dst, src, types)
+	}
+	var err error
+	src.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		dfd := dst.Descriptor().Fields().ByNumber(fd.Number())
+		if fd.IsExtension() {
+			var xt protoreflect.ExtensionType
+			if xt, err = types.FindExtensionByName(fd.FullName()); err != nil {
+				return false
+			}
+			dfd = xt.TypeDescriptor()
+		}
+		switch {
+		case fd.IsList():
+			src, dst := v.List(), dst.Mutable(dfd).List()
+			for i := 0; i < src.Len(); i++ {
+				var v protoreflect.Value
+				if v, err = p.copyValue(dfd, src.Get(i), dst.NewElement, types); err != nil {
+					return false
+				}
+				dst.Append(v)
+			}
+		case fd.IsMap():
+			dst := dst.Mutable(dfd).Map()
+			v.Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+				if v, err = p.copyValue(dfd.MapValue(), v, dst.NewValue, types); err != nil {
+					return false
+				}
+				dst.Set(k, v)
+				return true
+			})
+		default:
+			if v, err = p.copyValue(dfd, v, func() protoreflect.Value { return dst.NewField(dfd) }, types); err == nil {
+				dst.Set(dfd, v)
+			}
+		}
+		if err != nil {
+			err = fmt.Errorf("%s: %w", fd.FullName(), err)
+		}
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	dst.SetUnknown(src.GetUnknown())
+	return nil
+}
+
+// copyValue converts a singular value of a field for the mirrored field
+// dfd. newValue returns a new message value for dfd.
+func (p *prettyText) copyValue(dfd protoreflect.FieldDescriptor, v protoreflect.Value, newValue func() protoreflect.Value, types resolver) (protoreflect.Value, error) {
+	switch m := v.Interface().(type) {
+	case protoreflect.Message:
+		if dfd.Kind() == protoreflect.StringKind {
+			s, err := formatPretty(m)
+			return protoreflect.ValueOfString(s), err
+		}
+		dst := newValue()
+		return dst, p.copyMessage(dst.Message(), m, types)
+	case string:
+		if dfd.Message() != nil {
+			dst := newValue()
+			return dst, parsePretty(m, dst.Message())
+		}
+	}
+	return v, nil
+}
+
+// copyAny copies an Any message, converting the message packed in it if
+// its type is known.
+func (p *prettyText) copyAny(dst, src protoreflect.Message, types resolver) error {
+	fields := src.Descriptor().Fields()
+	url := src.Get(fields.ByName("type_url")).String()
+	b := src.Get(fields.ByName("value")).Bytes()
+	if mt, err := p.sourceTypes(types).FindMessageByURL(url); err == nil {
+		m := mt.New()
+		if err := (proto.UnmarshalOptions{Resolver: p.sourceTypes(types)}).Unmarshal(b, m.Interface()); err != nil {
+			return fmt.Errorf("%s: %w", url, err)
+		}
+		converted, err := p.convert(m, types)
+		if err != nil {
+			return fmt.Errorf("%s: %w", url, err)
+		}
+		if b, err = (proto.MarshalOptions{Deterministic: true}).Marshal(converted.Interface()); err != nil {
+			return err
+		}
+	}
+	fields = dst.Descriptor().Fields()
+	if url != "" {
+		dst.Set(fields.ByName("type_url"), protoreflect.ValueOfString(url))
+	}
+	if len(b) > 0 {
+		dst.Set(fields.ByName("value"), protoreflect.ValueOfBytes(b))
+	}
+	return nil
+}
+
+// sourceTypes returns the types a message is converted from when it is
+// converted to a message of the given types.
+func (p *prettyText) sourceTypes(types resolver) resolver {
+	if types == resolver(p.pretty) {
+		return p.types
+	}
+	return p.pretty
+}
+
+// formatPretty returns the pretty text string of a message of one of the
+// prettyTypes.
+func formatPretty(m protoreflect.Message) (string, error) {
+	if m.Descriptor().FullName() == "google.protobuf.Duration" {
+		fields := m.Descriptor().Fields()
+		return formatDuration(m.Get(fields.ByName("seconds")).Int(), m.Get(fields.ByName("nanos")).Int()), nil
+	}
+	b, err := protojson.Marshal(m.Interface())
+	if err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	if err := json.Compact(buf, b); err != nil {
+		return "", err
+	}
+	if m.Descriptor().FullName() == "google.protobuf.Timestamp" {
+		return strconv.Unquote(buf.String())
+	}
+	return buf.String(), nil
+}
+
+// parsePretty parses the pretty text string of a message of one of the
+// prettyTypes into m. Durations are also accepted in the format of Go's
+// time.ParseDuration, e.g. "1m30s".
+func parsePretty(s string, m protoreflect.Message) error {
+	switch m.Descriptor().FullName() {
+	case "google.protobuf.Timestamp":
+		s = strconv.Quote(s)
+	case "google.protobuf.Duration":
+		if d, err := time.ParseDuration(s); err == nil {
+			s = formatDuration(int64(d/time.Second), int64(d%time.Second))
+		}
+		s = strconv.Quote(s)
+	}
+	if err := protojson.Unmarshal([]byte(s), m.Interface()); err != nil {
+		return fmt.Errorf("invalid %s: %w", m.Descriptor().Name(), err)
+	}
+	return nil
+}
+
+// formatDuration formats a duration like protojson, but without trailing
+// zeros in the fraction of seconds.
+func formatDuration(seconds, nanos int64) string {
+	sign := ""
+	if seconds < 0 || nanos < 0 {
+		sign, seconds, nanos = "-", -seconds, -nanos
+	}
+	s := sign + strconv.FormatInt(seconds, 10)
+	if nanos != 0 {
+		s += "." + strings.TrimRight(fmt.Sprintf("%09d", nanos), "0")
+	}
+	return s + "s"
+}
+
+// prettyOnce holds the prettyText of the types of a PBConfig, which is
+// built once and shared by all conversions, also concurrent batch ones.
+type prettyOnce struct {
+	once   sync.Once
+	pretty *prettyText
+	err    error
+}
+
+// prettyText returns the prettyText of the given types, reusing the one of
+// the PBConfig types. Other types, such as those extended with an input
+// FileDescriptorSet, get a new one.
+func (c *PBConfig) prettyText(types resolver) (*prettyText, error) {
+	r, ok := types.(messageRanger)
+	if !ok {
+		return nil, fmt.Errorf("pretty text is not supported with %T", types)
+	}
+	if c.pretty == nil || types != resolver(c.types) {
+		return newPrettyText(r)
+	}
+	c.pretty.once.Do(func() { c.pretty.pretty, c.pretty.err = newPrettyText(r) })
+	return c.pretty.pretty, c.pretty.err
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"foxygo.at/protog/registry"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const prettyEvent = `{
+  "name": "e",
+  "time": "2021-02-03T04:05:06Z",
+  "elapsed": "1.500s",
+  "attrs": {"a": 1, "b": [true, null]},
+  "value": "hi",
+  "detail": {"@type": "type.googleapis.com/pbtest.Event", "time": "2020-01-01T00:00:00.250Z"},
+  "history": ["2021-01-01T00:00:00Z"],
+  "timeouts": {"x": "-0.000000001s"}
+}`
+
+func TestRunPretty(t *testing.T) {
+	tmpDir := t.TempDir()
+	fds := newFDS(t, "testdata/pbtest.pb")
+
+	// json -> pretty txt -> json round trip
+	cli := PBConfig{
+		Protoset:    fds,
+		Out:         filepath.Join(tmpDir, "out.txt"),
+		MessageType: "Event",
+		In:          prettyEvent,
+		Pretty:      true,
+	}
+	require.NoError(t, cli.Run())
+	b, err := os.ReadFile(cli.Out)
+	require.NoError(t, err)
+	txt := strings.Join(strings.Fields(string(b)), " ")
+	require.Contains(t, txt, `time: "2021-02-03T04:05:06Z"`)
+	require.Contains(t, txt, `elapsed: "1.5s"`)
+	require.Contains(t, txt, `attrs: "{\"a\":1,\"b\":[true,null]}"`)
+	require.Contains(t, txt, `value: "\"hi\""`)
+	require.Contains(t, txt, `[type.googleapis.com/pbtest.Event]: { time: "2020-01-01T00:00:00.250Z" }`)
+	require.Contains(t, txt, `history: "2021-01-01T00:00:00Z"`)
+	require.Contains(t, txt, `value: "-0.000000001s"`)
+
+	cli = PBConfig{
+		Protoset:    fds,
+		Out:         filepath.Join(tmpDir, "out.json"),
+		MessageType: "Event",
+		In:          "@" + cli.Out,
+		Pretty:      true,
+	}
+	require.NoError(t, cli.Run())
+	requireJSONFileContent(t, prettyEvent, cli.Out)
+
+	// without --pretty the well-known types are plain messages
+	cli = PBConfig{
+		Protoset:    fds,
+		Out:         filepath.Join(tmpDir, "plain.txt"),
+		MessageType: "Event",
+		In:          prettyEvent,
+	}
+	require.NoError(t, cli.Run())
+	b, err = os.ReadFile(cli.Out)
+	require.NoError(t, err)
+	require.Contains(t, strings.Join(strings.Fields(string(b)), " "), `elapsed: { seconds: 1 nanos: 500000000 }`)
+}
+
+func TestRunPrettyInput(t *testing.T) {
+	cli := PBConfig{
+		Protoset:    newFDS(t, "testdata/pbtest.pb"),
+		Out:         filepath.Join(t.TempDir(), "out.json"),
+		MessageType: "Event",
+		In:          `elapsed: "1m30s" attrs: "{}" timeouts: {key: "x" value: "250ms"}`,
+		InFormat:    "txt",
+		Pretty:      true,
+	}
+	require.NoError(t, cli.Run())
+	requireJSONFileContent(t, `{"elapsed": "90s", "attrs": {}, "timeouts": {"x": "0.250s"}}`, cli.Out)
+
+	cli.In = `time: "yesterday"`
+	err := cli.Run()
+	require.Error(t, err)
+	require.True(t, strings.HasPrefix(err.Error(), "pbtest.Event.time: invalid Timestamp: "))
+	require.Contains(t, err.Error(), `invalid google.protobuf.Timestamp value "yesterday"`)
+	cli.In = `attrs: "[]"`
+	require.Error(t, cli.Run())
+	cli.In = `time: {seconds: 1}`
+	require.Error(t, cli.Run())
+}
+
+func TestPrettyTextReused(t *testing.T) {
+	cli := PBConfig{Protoset: newFDS(t, "testdata/pbtest.pb"), Pretty: true}
+	require.NoError(t, cli.loadTypes())
+	p1, err := cli.prettyText(cli.types)
+	require.NoError(t, err)
+	p2, err := cli.prettyText(cli.types)
+	require.NoError(t, err)
+	require.Same(t, p1, p2)
+
+	other, err := cli.prettyText(registry.CloneTypes(cli.types))
+	require.NoError(t, err)
+	require.NotSame(t, p1, other)
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := map[string]*durationpb.Duration{
+		"0s":            {},
+		"1s":            {Seconds: 1},
+		"1.5s":          {Seconds: 1, Nanos: 500000000},
+		"-1.000001s":    {Seconds: -1, Nanos: -1000},
+		"-0.000000001s": {Nanos: -1},
+	}
+	for want, d := range tests {
+		got, err := formatPretty(d.ProtoReflect())
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+		parsed := &durationpb.Duration{}
+		require.NoError(t, parsePretty(got, parsed.ProtoReflect()))
+		require.Equal(t, d.AsDuration(), parsed.AsDuration())
+	}
+}
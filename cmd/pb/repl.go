@@ -0,0 +1,583 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+type ReplCmd struct {
+	Protoset    *descriptorpb.FileDescriptorSet `short:"P" help:"Protoset containing the message types"`
+	MessageType string                          `arg:"" help:"Message type to start with" optional:""`
+	In          string                          `arg:"" help:"Message value or @file to start with" optional:""`
+}
+
+var errExit = errors.New("exit")
+
+var replCommands = []struct {
+	name, args, help string
+}{
+	{"new", "TYPE", "start a new message of the given type"},
+	{"load", "FILE [FORMAT]", "load a message of the current type, in the format of the file extension by default"},
+	{"save", "FILE [FORMAT]", "save the message, in the format of the file extension by default"},
+	{"show", "[FORMAT]", "show the current message, as txt by default"},
+	{"ls", "", "list the fields of the current message"},
+	{"cd", "PATH", "navigate to a message field: FIELD, FIELD[INDEX], FIELD[KEY], .. or /, separated by /"},
+	{"set", "FIELD VALUE", "set a field; messages, repeated and map fields take JSON"},
+	{"add", "FIELD [VALUE]", "append to a repeated field, navigating into a new message element without VALUE"},
+	{"clear", "FIELD", "clear a field"},
+	{"help", "", "show this help"},
+	{"exit", "", "leave the REPL"},
+}
+
+var replFormats = []string{"json", "jsonl", "pb", "pbd", "txt", "csv", "tsv", "grpc", "grpc-web-text"}
+
+// repl is an interactive session editing a message. stack holds the root
+// message and the messages navigated into below it.
+type repl struct {
+	pb    *PBConfig
+	out   io.Writer
+	color bool
+	stack []replFrame
+}
+
+type replFrame struct {
+	name string
+	m    protoreflect.Message
+}
+
+// Run reads commands from the terminal, with tab completion of commands,
+// field names and values, or one command per line from other input, in
+// which case the first failing command ends the session.
+func (c *ReplCmd) Run() error {
+	r := &repl{pb: &PBConfig{Protoset: c.Protoset}, out: os.Stdout}
+	if err := r.pb.loadTypes(); err != nil {
+		return err
+	}
+	if c.MessageType != "" {
+		if err := r.exec("new " + c.MessageType); err != nil {
+			return err
+		}
+		if c.In != "" {
+			if err := r.loadInput(c.In, ""); err != nil {
+				return err
+			}
+		}
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return r.runScript(os.Stdin)
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state) //nolint:errcheck
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, "")
+	t.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		if key != '\t' {
+			return "", 0, false
+		}
+		return r.autoComplete(t, line, pos)
+	}
+	r.out, r.color = t, useColor("auto", true)
+	for {
+		t.SetPrompt(r.prompt())
+		line, err := t.ReadLine()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := r.exec(line); errors.Is(err, errExit) {
+			return nil
+		} else if err != nil {
+			fmt.Fprintln(t, "error:", err)
+		}
+	}
+}
+
+func (r *repl) runScript(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	for n := 1; scanner.Scan(); n++ {
+		if err := r.exec(scanner.Text()); errors.Is(err, errExit) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func (r *repl) prompt() string {
+	if len(r.stack) == 0 {
+		return "pb> "
+	}
+	names := make([]string, len(r.stack))
+	for i, frame := range r.stack {
+		names[i] = frame.name
+	}
+	return strings.Join(names, "/") + "> "
+}
+
+// autoComplete completes the word before pos in line to the longest
+// common prefix of its completions, and lists the completions if there is
+// nothing to add.
+func (r *repl) autoComplete(t *term.Terminal, line string, pos int) (string, int, bool) {
+	before := line[:pos]
+	word := before[strings.LastIndex(before, " ")+1:]
+	candidates := r.complete(before)
+	if len(candidates) == 0 {
+		return "", 0, false
+	}
+	prefix := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(candidates) == 1 && !strings.HasSuffix(prefix, "/") {
+		prefix += " "
+	}
+	if len(prefix) <= len(word) {
+		fmt.Fprintln(t, strings.Join(candidates, "  "))
+		return "", 0, false
+	}
+	completed := before + prefix[len(word):]
+	return completed + line[pos:], len(completed), true
+}
+
+// complete returns the completions of the last word of line.
+func (r *repl) complete(line string) []string {
+	args := strings.Fields(line)
+	if strings.HasSuffix(line, " ") || len(args) == 0 {
+		args = append(args, "")
+	}
+	word := args[len(args)-1]
+	var candidates []string
+	switch {
+	case len(args) == 1:
+		for _, c := range replCommands {
+			candidates = append(candidates, c.name)
+		}
+	case args[0] == "new" && len(args) == 2:
+		r.pb.types.RangeMessages(func(mt protoreflect.MessageType) bool {
+			if !mt.Descriptor().IsMapEntry() {
+				candidates = append(candidates, string(mt.Descriptor().FullName()))
+			}
+			return true
+		})
+	case (args[0] == "load" || args[0] == "save") && len(args) == 2:
+		return completeFile(word)
+	case (args[0] == "load" || args[0] == "save") && len(args) == 3, args[0] == "show" && len(args) == 2:
+		candidates = replFormats
+	case len(r.stack) == 0:
+	case args[0] == "cd" && len(args) == 2:
+		i := strings.LastIndex(word, "/") + 1
+		if frames, err := r.navigate(word[:i]); err == nil {
+			m := frames[len(frames)-1].m
+			candidates = append(fieldNames(m.Descriptor(), true), "..")
+			for j := range candidates {
+				candidates[j] = word[:i] + candidates[j]
+			}
+		}
+	case (args[0] == "set" || args[0] == "add" || args[0] == "clear") && len(args) == 2:
+		candidates = fieldNames(r.current().Descriptor(), false)
+	case args[0] == "set" && len(args) == 3:
+		if fd := lookupField(r.current().Descriptor(), args[1]); fd != nil {
+			candidates = valueNames(fd)
+		}
+	}
+	return withPrefix(candidates, word)
+}
+
+func completeFile(prefix string) []string {
+	matches, _ := filepath.Glob(prefix + "*")
+	for i, match := range matches {
+		if info, err := os.Stat(match); err == nil && info.IsDir() {
+			matches[i] += "/"
+		}
+	}
+	return matches
+}
+
+func withPrefix(candidates []string, prefix string) []string {
+	var result []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) {
+			result = append(result, c)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// fieldNames returns the field names of md, only of message fields if
+// messages is set.
+func fieldNames(md protoreflect.MessageDescriptor, messages bool) []string {
+	var result []string
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.IsMap() {
+			fd = fd.MapValue()
+		}
+		if !messages || fd.Message() != nil {
+			result = append(result, string(fields.Get(i).Name()))
+		}
+	}
+	return result
+}
+
+func valueNames(fd protoreflect.FieldDescriptor) []string {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return []string{"true", "false"}
+	case protoreflect.EnumKind:
+		values := fd.Enum().Values()
+		result := make([]string, values.Len())
+		for i := range result {
+			result[i] = string(values.Get(i).Name())
+		}
+		return result
+	}
+	return nil
+}
+
+func lookupField(md protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	if fd := md.Fields().ByName(protoreflect.Name(name)); fd != nil {
+		return fd
+	}
+	return md.Fields().ByJSONName(name)
+}
+
+func (r *repl) current() protoreflect.Message {
+	return r.stack[len(r.stack)-1].m
+}
+
+func (r *repl) exec(line string) error {
+	cmd, rest := splitWord(line)
+	switch {
+	case cmd == "", strings.HasPrefix(cmd, "#"):
+		return nil
+	}
+	switch cmd {
+	case "help":
+		for _, c := range replCommands {
+			fmt.Fprintf(r.out, "  %-6s %-15s %s\n", c.name, c.args, c.help)
+		}
+		return nil
+	case "exit", "quit":
+		return errExit
+	case "new":
+		mt, err := lookupMessage(r.pb.types, rest)
+		if err != nil {
+			return err
+		}
+		r.stack = []replFrame{{string(mt.Descriptor().FullName()), mt.New()}}
+		return nil
+	}
+	if len(r.stack) == 0 {
+		return fmt.Errorf("no message, start one with: new TYPE")
+	}
+	m := r.current()
+	switch cmd {
+	case "load":
+		file, format := splitWord(rest)
+		return r.loadInput("@"+file, format)
+	case "save":
+		file, format := splitWord(rest)
+		return r.save(file, format)
+	case "show":
+		return r.show(m, rest)
+	case "ls":
+		return r.list(m)
+	case "cd":
+		frames, err := r.navigate(rest)
+		if err != nil {
+			return err
+		}
+		r.stack = frames
+		return nil
+	}
+	name, value := splitWord(rest)
+	fd := lookupField(m.Descriptor(), name)
+	if fd == nil {
+		return fmt.Errorf("no field %q in %s", name, m.Descriptor().FullName())
+	}
+	switch cmd {
+	case "set", "add":
+		m, err := r.mutable()
+		if err != nil {
+			return err
+		}
+		if cmd == "set" {
+			return setField(m, fd, value, r.pb.types)
+		}
+		return r.add(m, fd, value)
+	case "clear":
+		if m.Has(fd) {
+			m.Clear(fd)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown command %q, see help", cmd)
+}
+
+func splitWord(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		return s[:i], strings.TrimSpace(s[i:])
+	}
+	return s, ""
+}
+
+// loadInput replaces the root message with the message read from in, in
+// the given format or the format of the file extension.
+func (r *repl) loadInput(in, format string) error {
+	pb := *r.pb
+	pb.In, pb.InFormat = in, format
+	b, err := pb.readInput()
+	if err != nil {
+		return err
+	}
+	root := r.stack[0]
+	messages, err := pb.decodeStream(root.m.Type(), b, pb.inFormat())
+	if err != nil {
+		return err
+	}
+	if len(messages) != 1 {
+		return fmt.Errorf("input has %d messages, want 1", len(messages))
+	}
+	r.stack = []replFrame{{root.name, messages[0].ProtoReflect()}}
+	return nil
+}
+
+func (r *repl) save(file, format string) error {
+	if file == "" {
+		return fmt.Errorf("missing file name")
+	}
+	pb := *r.pb
+	pb.Out, pb.OutFormat = file, format
+	root := r.stack[0].m
+	b, err := pb.encodeStream(root.Type(), []proto.Message{root.Interface()})
+	if err != nil {
+		return err
+	}
+	return pb.writeOutput(b)
+}
+
+// show writes m in the given format, as a hex dump for binary formats.
+func (r *repl) show(m protoreflect.Message, format string) error {
+	pb := *r.pb
+	pb.OutFormat = "txt"
+	if format != "" {
+		pb.OutFormat = format
+	}
+	b, err := pb.encodeStream(m.Type(), []proto.Message{m.Interface()})
+	if err != nil {
+		return err
+	}
+	format = pb.outFormat()
+	switch {
+	case isBinary(format):
+		b = []byte(hex.Dump(b))
+	case r.color:
+		b = highlight(b, format)
+	}
+	_, err = r.out.Write(b)
+	return err
+}
+
+// list writes the fields of m with their types and values.
+func (r *repl) list(m protoreflect.Message) error {
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		value := "-"
+		switch {
+		case !m.Has(fd):
+		case fd.IsList():
+			value = fmt.Sprintf("[%d]", m.Get(fd).List().Len())
+		case fd.IsMap():
+			value = fmt.Sprintf("{%d}", m.Get(fd).Map().Len())
+		default:
+			s, err := formatValue(fd, m.Get(fd), r.pb.types)
+			if err != nil {
+				return err
+			}
+			value = s
+		}
+		fmt.Fprintf(r.out, "  %-3d %-20s %-30s %s\n", fd.Number(), fd.Name(), fieldTypeName(fd), value)
+	}
+	return nil
+}
+
+func fieldTypeName(fd protoreflect.FieldDescriptor) string {
+	if fd.IsMap() {
+		return "map<" + fieldTypeName(fd.MapKey()) + ", " + fieldTypeName(fd.MapValue()) + ">"
+	}
+	name := fd.Kind().String()
+	switch {
+	case fd.Message() != nil:
+		name = string(fd.Message().FullName())
+	case fd.Enum() != nil:
+		name = string(fd.Enum().FullName())
+	}
+	if fd.IsList() {
+		return "repeated " + name
+	}
+	return name
+}
+
+// navigate returns the stack of messages after following path from the
+// current message. Unset message fields on the path are read-only empty
+// messages, created by mutable when a field below them is set.
+func (r *repl) navigate(path string) ([]replFrame, error) {
+	frames := append([]replFrame{}, r.stack...)
+	if strings.HasPrefix(path, "/") {
+		frames = frames[:1]
+	}
+	for _, part := range strings.Split(path, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(frames) > 1 {
+				frames = frames[:len(frames)-1]
+			}
+			continue
+		}
+		m, err := childMessage(frames[len(frames)-1].m, part, false)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, replFrame{part, m})
+	}
+	return frames, nil
+}
+
+// mutable returns the current message after creating the message fields
+// on the path to it from the root message.
+func (r *repl) mutable() (protoreflect.Message, error) {
+	frames := []replFrame{r.stack[0]}
+	for _, frame := range r.stack[1:] {
+		m, err := childMessage(frames[len(frames)-1].m, frame.name, true)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, replFrame{frame.name, m})
+	}
+	r.stack = frames
+	return r.current(), nil
+}
+
+// childMessage returns the message of the field named by part, which is
+// FIELD, FIELD[INDEX] for a repeated field or FIELD[KEY] for a map field.
+// If create is set, unset message fields and map entries are created.
+func childMessage(m protoreflect.Message, part string, create bool) (protoreflect.Message, error) {
+	name, index := part, ""
+	if i := strings.IndexByte(part, '['); i >= 0 && strings.HasSuffix(part, "]") {
+		name, index = part[:i], part[i+1:len(part)-1]
+	}
+	fd := lookupField(m.Descriptor(), name)
+	switch {
+	case fd == nil:
+		return nil, fmt.Errorf("no field %q in %s", name, m.Descriptor().FullName())
+	case fd.IsList() && fd.Message() != nil && index != "":
+		list := m.Get(fd).List()
+		i, err := strconv.Atoi(index)
+		if err != nil || i < 0 || i >= list.Len() {
+			return nil, fmt.Errorf("invalid index %q of %s with %d elements", index, name, list.Len())
+		}
+		return list.Get(i).Message(), nil
+	case fd.IsMap() && fd.MapValue().Message() != nil && index != "":
+		key, err := parseValue(fd.MapKey(), index, protoreflect.Value{}, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q of %s: %w", index, name, err)
+		}
+		if create {
+			return m.Mutable(fd).Map().Mutable(key.MapKey()).Message(), nil
+		}
+		if v := m.Get(fd).Map().Get(key.MapKey()); v.IsValid() {
+			return v.Message(), nil
+		}
+		return m.NewField(fd).Map().NewValue().Message(), nil
+	case !fd.IsList() && !fd.IsMap() && fd.Message() != nil && index == "":
+		if !create {
+			return m.Get(fd).Message(), nil
+		}
+		return m.Mutable(fd).Message(), nil
+	}
+	return nil, fmt.Errorf("cannot navigate to %s, %s", part, fieldTypeName(fd))
+}
+
+// setField sets a field from a string value. Singular fields take values
+// as in CSV cells, messages as JSON. Repeated and map fields take JSON.
+// Messages in Any fields are resolved with types.
+func setField(m protoreflect.Message, fd protoreflect.FieldDescriptor, value string, types resolver) error {
+	if fd.IsList() || fd.IsMap() {
+		tmp := m.Type().New()
+		b := []byte(`{"` + fd.JSONName() + `":` + value + `}`)
+		if err := (protojson.UnmarshalOptions{Resolver: types}).Unmarshal(b, tmp.Interface()); err != nil {
+			return fmt.Errorf("invalid JSON value for %s: %w", fd.Name(), err)
+		}
+		m.Set(fd, tmp.Get(fd))
+		return nil
+	}
+	if fd.Kind() == protoreflect.StringKind || fd.Kind() == protoreflect.BytesKind {
+		if s, err := strconv.Unquote(value); err == nil {
+			value = s
+		}
+	}
+	v, err := parseValue(fd, value, m.NewField(fd), types)
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %w", fd.Name(), err)
+	}
+	m.Set(fd, v)
+	return nil
+}
+
+// add appends a value to a repeated field. Without a value, a new message
+// element is appended and navigated into.
+func (r *repl) add(m protoreflect.Message, fd protoreflect.FieldDescriptor, value string) error {
+	if !fd.IsList() {
+		return fmt.Errorf("%s is not a repeated field", fd.Name())
+	}
+	list := m.Mutable(fd).List()
+	if value == "" && fd.Message() != nil {
+		v := list.NewElement()
+		list.Append(v)
+		name := fmt.Sprintf("%s[%d]", fd.Name(), list.Len()-1)
+		r.stack = append(r.stack, replFrame{name, v.Message()})
+		return nil
+	}
+	if fd.Kind() == protoreflect.StringKind || fd.Kind() == protoreflect.BytesKind {
+		if s, err := strconv.Unquote(value); err == nil {
+			value = s
+		}
+	}
+	v, err := parseValue(fd, value, list.NewElement(), r.pb.types)
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %w", fd.Name(), err)
+	}
+	list.Append(v)
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/term"
+)
+
+func newTestRepl(t *testing.T) (*repl, *bytes.Buffer) {
+	t.Helper()
+	out := &bytes.Buffer{}
+	r := &repl{pb: &PBConfig{Protoset: newFDS(t, "testdata/pbtest.pb")}, out: out}
+	require.NoError(t, r.pb.loadTypes())
+	return r, out
+}
+
+func TestRepl(t *testing.T) {
+	r, out := newTestRepl(t)
+	saved := filepath.Join(t.TempDir(), "saved.json")
+	script := `# build a record
+new Record
+set id "a b"
+set status FAILED
+set tags ["x", "y"]
+add tags z
+add items
+set name i1
+cd ../main
+set count 3
+cd /
+set time 2021-01-01T00:00:00Z
+set labels {"k": "v"}
+save ` + saved + `
+clear tags
+load ` + saved + `
+cd items[0]
+show json
+exit
+show
+`
+	require.NoError(t, r.runScript(strings.NewReader(script)))
+	require.JSONEq(t, `{"name": "i1"}`, out.String())
+	requireJSONFileContent(t, `{
+  "id": "a b",
+  "status": "FAILED",
+  "tags": ["x", "y", "z"],
+  "items": [{"name": "i1"}],
+  "main": {"count": 3},
+  "time": "2021-01-01T00:00:00Z",
+  "labels": {"k": "v"}
+}`, saved)
+	require.Equal(t, "pbtest.Record/items[0]> ", r.prompt())
+
+	out.Reset()
+	require.NoError(t, r.exec("cd /"))
+	require.NoError(t, r.exec("ls"))
+	require.Contains(t, out.String(), "  2   status               pbtest.Record.Status           FAILED\n")
+	require.Contains(t, out.String(), "  8   labels               map<string, string>            {1}\n")
+	require.Contains(t, out.String(), "  12  parent               pbtest.Record                  -\n")
+
+	out.Reset()
+	require.NoError(t, r.exec("show pb"))
+	require.Contains(t, out.String(), "00000000  ")
+}
+
+func TestReplNavigateReadOnly(t *testing.T) {
+	r, out := newTestRepl(t)
+	require.NoError(t, r.runScript(strings.NewReader("new Record\ncd parent/main\nls\nclear name\ncd /\nshow json\n")))
+	require.True(t, strings.HasSuffix(out.String(), "{}\n"), out.String())
+
+	out.Reset()
+	script := `new Event
+cd timeouts[t]
+cd /
+show json
+cd timeouts[t]
+set seconds 5
+cd /
+set detail {"@type": "type.googleapis.com/pbtest.Event", "name": "inner"}
+show json
+`
+	require.NoError(t, r.runScript(strings.NewReader(script)))
+	shown := strings.SplitN(out.String(), "\n", 2)
+	require.JSONEq(t, `{}`, shown[0])
+	require.JSONEq(t, `{"timeouts": {"t": "5s"}, "detail": {"@type": "type.googleapis.com/pbtest.Event", "name": "inner"}}`, shown[1])
+}
+
+func TestReplErr(t *testing.T) {
+	r, _ := newTestRepl(t)
+	require.EqualError(t, r.exec("ls"), "no message, start one with: new TYPE")
+	require.EqualError(t, r.runScript(strings.NewReader("new Record\nfrobnicate x\n")), `line 2: no field "x" in pbtest.Record`)
+	for _, line := range []string{
+		"new Unknown",
+		"frobnicate id",
+		"set nope 1",
+		"set latency_ms x",
+		"set tags 1",
+		"add id x",
+		"cd id",
+		"cd items[0]",
+		"cd labels[x]",
+		"cd nope",
+		"save",
+		"load testdata/missing.json",
+		"show xml",
+	} {
+		require.Error(t, r.exec(line), line)
+	}
+}
+
+func TestReplComplete(t *testing.T) {
+	r, _ := newTestRepl(t)
+	require.Equal(t, []string{"save", "set", "show"}, r.complete("s"))
+	require.Equal(t, []string{"pbtest.Record", "pbtest.Record.Item"}, r.complete("new pbtest.Rec"))
+	require.Empty(t, r.complete("set "))
+	require.NoError(t, r.exec("new Record"))
+	require.Equal(t, []string{"labels", "latency_ms"}, r.complete("set la"))
+	require.Equal(t, []string{"FAILED"}, r.complete("set status F"))
+	require.Equal(t, []string{"false", "true"}, r.complete("set ok "))
+	require.Equal(t, []string{"..", "items", "main", "parent", "time"}, r.complete("cd "))
+	require.Equal(t, []string{"parent/main"}, r.complete("cd parent/m"))
+	require.Equal(t, []string{"json", "jsonl"}, r.complete("show js"))
+	require.Equal(t, []string{"testdata/pbtest.pb", "testdata/pbtest.proto"}, r.complete("load testdata/pbtest."))
+	require.Equal(t, []string{"testdata/golden/"}, r.complete("save testdata/go"))
+	// completion does not create the fields it navigates through
+	require.False(t, r.current().Has(r.current().Descriptor().Fields().ByName("parent")))
+}
+
+func TestReplAutoComplete(t *testing.T) {
+	r, _ := newTestRepl(t)
+	require.NoError(t, r.exec("new Record"))
+	buf := &bytes.Buffer{}
+	tm := term.NewTerminal(buf, "")
+
+	line, pos, ok := r.autoComplete(tm, "sh x", 2)
+	require.True(t, ok)
+	require.Equal(t, "show  x", line)
+	require.Equal(t, 5, pos)
+
+	line, pos, ok = r.autoComplete(tm, "cd pa", 5)
+	require.True(t, ok)
+	require.Equal(t, "cd parent ", line)
+	require.Equal(t, 10, pos)
+
+	_, _, ok = r.autoComplete(tm, "s", 1)
+	require.False(t, ok)
+	require.Contains(t, buf.String(), "save  set  show")
+
+	_, _, ok = r.autoComplete(tm, "xyz", 3)
+	require.False(t, ok)
+}
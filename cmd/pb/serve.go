@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"foxygo.at/protog/registry"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+type ServeCmd struct {
+	Listen            string        `short:"l" help:"Address to listen on" default:":8080"`
+	Protoset          string        `short:"P" help:"Protoset containing the message types, reloaded on SIGHUP" type:"existingfile"`
+	Zero              bool          `short:"z" help:"Print zero values in JSON output"`
+	MaxInputBytes     int64         `help:"Maximum number of bytes of a request body (0: no limit)" default:"16777216"`
+	MaxDepth          int           `help:"Maximum nesting depth of decoded messages (0: no limit)"`
+	MaxRepeated       int           `help:"Maximum number of elements of a decoded repeated or map field (0: no limit)"`
+	MaxSize           int64         `help:"Maximum estimated memory size of the decoded messages of a request (0: no limit)"`
+	ReadHeaderTimeout time.Duration `help:"Maximum duration for reading request headers" default:"10s"`
+	ReadTimeout       time.Duration `help:"Maximum duration for reading a request" default:"1m"`
+	WriteTimeout      time.Duration `help:"Maximum duration for handling a request and writing its response" default:"1m"`
+
+	types atomic.Value // *protoregistry.Types
+}
+
+// mediaTypes maps the media types of requests and responses to formats.
+var mediaTypes = map[string]string{
+	"application/json":                 "json",
+	"application/x-ndjson":             "jsonl",
+	"application/jsonl":                "jsonl",
+	"application/x-protobuf":           "pb",
+	"application/protobuf":             "pb",
+	"application/octet-stream":         "pb",
+	"application/x-protobuf-delimited": "pbd",
+	"text/plain":                       "txt",
+	"text/x-protobuf":                  "txt",
+	"text/csv":                         "csv",
+	"text/tab-separated-values":        "tsv",
+	"application/grpc":                 "grpc",
+	"application/grpc-web-text":        "grpc-web-text",
+}
+
+// formatMediaTypes are the media types of responses in each format.
+var formatMediaTypes = map[string]string{
+	"json":          "application/json",
+	"jsonl":         "application/x-ndjson",
+	"pb":            "application/x-protobuf",
+	"pbd":           "application/x-protobuf-delimited",
+	"txt":           "text/plain",
+	"csv":           "text/csv",
+	"tsv":           "text/tab-separated-values",
+	"grpc":          "application/grpc",
+	"grpc-web-text": "application/grpc-web-text",
+}
+
+// Run serves conversions over HTTP. A request POSTs a message in the
+// format given by its Content-Type to /<message-type> or /?type=<message
+// type>, and the response holds the message in the format of the supported
+// media type of its Accept header with the highest quality value, JSON by
+// default. The protoset is reloaded on SIGHUP.
+func (c *ServeCmd) Run() error {
+	if err := c.load(); err != nil {
+		return err
+	}
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+	go c.reloadOn(sighup)
+	fmt.Fprintf(os.Stderr, "listening on %s\n", c.Listen)
+	return c.server().ListenAndServe()
+}
+
+func (c *ServeCmd) server() *http.Server {
+	return &http.Server{
+		Addr:              c.Listen,
+		Handler:           c,
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+		ReadTimeout:       c.ReadTimeout,
+		WriteTimeout:      c.WriteTimeout,
+	}
+}
+
+// load builds the type registry from the global types and the protoset.
+func (c *ServeCmd) load() error {
+	types := registry.CloneTypes(protoregistry.GlobalTypes)
+	if c.Protoset != "" {
+		fds, err := readProtoset(c.Protoset)
+		if err != nil {
+			return err
+		}
+		if err := registry.AddDynamicTypes(types, fds); err != nil {
+			return err
+		}
+	}
+	c.types.Store(types)
+	return nil
+}
+
+// reloadOn reloads the protoset for every signal received on ch. Requests
+// in flight keep using the types they started with and the previous types
+// are kept if the protoset cannot be loaded.
+func (c *ServeCmd) reloadOn(ch <-chan os.Signal) {
+	for range ch {
+		if err := c.load(); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot reload %s: %v\n", c.Protoset, err)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "reloaded %s\n", c.Protoset)
+	}
+}
+
+func (c *ServeCmd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed, POST a message", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if name == "" {
+		name = r.URL.Query().Get("type")
+	}
+	if name == "" {
+		http.Error(w, "missing message type, POST to /<type> or /?type=<type>", http.StatusBadRequest)
+		return
+	}
+	inFormat, err := requestFormat(r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	outFormat, err := responseFormat(r.Header.Get("Accept"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
+		return
+	}
+
+	pb := &PBConfig{
+		InFormat:      inFormat,
+		OutFormat:     outFormat,
+		Zero:          c.Zero && (outFormat == "json" || outFormat == "jsonl"),
+		MaxInputBytes: c.MaxInputBytes,
+		MaxDepth:      c.MaxDepth,
+		MaxRepeated:   c.MaxRepeated,
+		MaxSize:       c.MaxSize,
+		types:         c.types.Load().(*protoregistry.Types),
+	}
+	mt, err := lookupMessage(pb.types, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	in, err := readLimited(r.Body, c.MaxInputBytes)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	b, err := pb.convert(mt, in, inFormat)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", formatMediaTypes[outFormat])
+	w.Write(b) //nolint:errcheck
+}
+
+func errorStatus(err error) int {
+	for _, limitErr := range []error{errInputTooLarge, errTooDeep, errTooManyElements, errTooLarge} {
+		if errors.Is(err, limitErr) {
+			return http.StatusRequestEntityTooLarge
+		}
+	}
+	return http.StatusBadRequest
+}
+
+// requestFormat returns the format of a request body with the given
+// Content-Type, JSON by default. Form bodies are also read as JSON, as
+// that is what curl -d sends without a Content-Type header.
+func requestFormat(contentType string) (string, error) {
+	if contentType == "" {
+		return "json", nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", fmt.Errorf("invalid Content-Type %q: %w", contentType, err)
+	}
+	if format, ok := mediaTypes[mediaType]; ok {
+		return format, nil
+	}
+	if mediaType == "application/x-www-form-urlencoded" {
+		return "json", nil
+	}
+	return "", fmt.Errorf("unsupported Content-Type %q", contentType)
+}
+
+// responseFormat returns the format of the supported media type of an
+// Accept header with the highest quality value, the first of them on a
+// tie, JSON by default. Media types with a quality value of 0 are not
+// acceptable.
+func responseFormat(accept string) (string, error) {
+	if accept == "" {
+		return "json", nil
+	}
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		format, ok := mediaTypes[mediaType]
+		if mediaType == "*/*" || mediaType == "application/*" {
+			format, ok = "json", true
+		}
+		if ok && q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	if best != "" {
+		return best, nil
+	}
+	return "", fmt.Errorf("no supported media type in Accept %q", accept)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/stretchr/testify/require"
+)
+
+func post(t *testing.T, url, contentType, accept, body string) (int, string, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	require.NoError(t, err)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, resp.Header.Get("Content-Type"), string(b)
+}
+
+func newTestServer(t *testing.T, cmd *ServeCmd) *httptest.Server {
+	t.Helper()
+	require.NoError(t, cmd.load())
+	s := httptest.NewServer(cmd)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestServe(t *testing.T) {
+	s := newTestServer(t, &ServeCmd{Protoset: "testdata/pbtest.pb"})
+
+	status, contentType, body := post(t, s.URL+"/Record", "application/json", "", `{"id": "a", "latencyMs": "5"}`)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "application/json", contentType)
+	require.JSONEq(t, `{"id": "a", "latencyMs": "5"}`, body)
+
+	status, contentType, txt := post(t, s.URL+"/?type=pbtest.Record", "application/json; charset=utf-8", "text/plain", `{"id": "a"}`)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "text/plain", contentType)
+	require.Equal(t, `id: "a"`, strings.Join(strings.Fields(txt), " "))
+
+	status, contentType, pb := post(t, s.URL+"/Record", "text/plain", "text/html, application/x-protobuf", txt)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "application/x-protobuf", contentType)
+	require.Equal(t, "\x0a\x01a", pb)
+
+	status, _, body = post(t, s.URL+"/Record", "application/x-protobuf-delimited", "application/x-ndjson", "\x03"+pb+"\x03"+pb)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "{\"id\":\"a\"}\n{\"id\":\"a\"}\n", body)
+
+	// curl -d without a Content-Type header sends a form
+	status, _, body = post(t, s.URL+"/Record", "application/x-www-form-urlencoded", "", `{"id": "a"}`)
+	require.Equal(t, http.StatusOK, status)
+	require.JSONEq(t, `{"id": "a"}`, body)
+}
+
+func TestServeDefaults(t *testing.T) {
+	var cli struct {
+		Serve ServeCmd `cmd:""`
+	}
+	parser, err := kong.New(&cli)
+	require.NoError(t, err)
+	_, err = parser.Parse([]string{"serve"})
+	require.NoError(t, err)
+	cmd := &cli.Serve
+	require.Equal(t, int64(16<<20), cmd.MaxInputBytes)
+
+	s := cmd.server()
+	require.Equal(t, 10*time.Second, s.ReadHeaderTimeout)
+	require.Equal(t, time.Minute, s.ReadTimeout)
+	require.Equal(t, time.Minute, s.WriteTimeout)
+}
+
+func TestServeErr(t *testing.T) {
+	s := newTestServer(t, &ServeCmd{Protoset: "testdata/pbtest.pb", MaxInputBytes: 20})
+	tests := map[string]struct {
+		path, contentType, accept, body string
+		wantStatus                      int
+	}{
+		"noType":      {"/", "", "", "{}", http.StatusBadRequest},
+		"unknownType": {"/Unknown", "", "", "{}", http.StatusNotFound},
+		"contentType": {"/Record", "image/png", "", "{}", http.StatusUnsupportedMediaType},
+		"badType":     {"/Record", "a/b/c", "", "{}", http.StatusUnsupportedMediaType},
+		"accept":      {"/Record", "", "image/png", "{}", http.StatusNotAcceptable},
+		"badInput":    {"/Record", "", "", `{"x": 1}`, http.StatusBadRequest},
+		"tooLarge":    {"/Record", "", "", `{"id": "0123456789abcdef"}`, http.StatusRequestEntityTooLarge},
+	}
+	for name, tc := range tests {
+		status, _, _ := post(t, s.URL+tc.path, tc.contentType, tc.accept, tc.body)
+		require.Equal(t, tc.wantStatus, status, name)
+	}
+
+	resp, err := http.Get(s.URL + "/Record")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestServeReload(t *testing.T) {
+	protoset := filepath.Join(t.TempDir(), "set.pb")
+	writeFile(t, protoset, "")
+	cmd := &ServeCmd{Protoset: protoset}
+	s := newTestServer(t, cmd)
+	status, _, _ := post(t, s.URL+"/Record", "", "", "{}")
+	require.Equal(t, http.StatusNotFound, status)
+
+	ch := make(chan os.Signal)
+	go cmd.reloadOn(ch)
+	defer close(ch)
+
+	b, err := os.ReadFile("testdata/pbtest.pb")
+	require.NoError(t, err)
+	writeFile(t, protoset, string(b))
+	ch <- syscall.SIGHUP
+	require.Eventually(t, func() bool {
+		status, _, _ := post(t, s.URL+"/Record", "", "", "{}")
+		return status == http.StatusOK
+	}, time.Second, 10*time.Millisecond)
+
+	// an invalid protoset keeps the previous types
+	writeFile(t, protoset, "invalid")
+	require.Error(t, cmd.load())
+	status, _, _ = post(t, s.URL+"/Record", "", "", "{}")
+	require.Equal(t, http.StatusOK, status)
+}
+
+func TestResponseFormat(t *testing.T) {
+	tests := map[string]string{
+		"":                                 "json",
+		"*/*":                              "json",
+		"text/html, application/*;q=0.8":   "json",
+		"text/csv":                         "csv",
+		"application/grpc-web-text, */*":   "grpc-web-text",
+		"application/x-protobuf;q=0.5, ;;": "pb",
+		"text/csv;q=0.5, text/plain":       "txt",
+		"text/csv, text/plain":             "csv",
+		"text/csv;q=0.9, */*;q=0.1":        "csv",
+		"text/csv;q=x, application/json":   "json",
+	}
+	for accept, want := range tests {
+		got, err := responseFormat(accept)
+		require.NoError(t, err, accept)
+		require.Equal(t, want, got, accept)
+	}
+	for _, accept := range []string{"text/html", "text/csv;q=0", "text/csv;q=2"} {
+		_, err := responseFormat(accept)
+		require.Error(t, err, accept)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+type SizeCmd struct {
+	Protoset      *descriptorpb.FileDescriptorSet `short:"P" help:"Protoset containing Message to be measured"`
+	Out           string                          `short:"o" help:"Output file name"`
+	InFormat      string                          `short:"I" help:"Input format (j[son], p[b], t[xt], jsonl, pbd, csv, tsv, grpc, grpc-web-text)" enum:"json,pb,txt,jsonl,pbd,csv,tsv,grpc,grpc-web-text,j,p,t," default:""`
+	InCompression string                          `help:"Input compression (gzip, zstd, snappy, none), detected by default" enum:"gzip,zstd,snappy,none," default:""`
+	MessageType   string                          `arg:"" help:"Message type to be measured"`
+	In            string                          `arg:"" help:"Message value JSON encoded" optional:""`
+}
+
+// sizeNode is a field path in the size breakdown of a message. Its size
+// includes the tags and lengths of the field on the wire, so children do
+// not add up to the size of their parent.
+type sizeNode struct {
+	name     string
+	count    int
+	size     int
+	children map[string]*sizeNode
+}
+
+// Run decodes a message or a stream of messages and reports how many
+// bytes each field path takes up in the binary encoding, summed over all
+// messages.
+func (c *SizeCmd) Run() error {
+	pb := &PBConfig{
+		Protoset:      c.Protoset,
+		Out:           c.Out,
+		InFormat:      c.InFormat,
+		InCompression: c.InCompression,
+		MessageType:   c.MessageType,
+		In:            c.In,
+		Color:         "never",
+	}
+	mt, err := pb.messageType()
+	if err != nil {
+		return err
+	}
+	in, err := pb.readInput()
+	if err != nil {
+		return err
+	}
+	messages, err := pb.decodeStream(mt, in, pb.inFormat())
+	if err != nil {
+		return err
+	}
+	md := mt.Descriptor()
+	root := &sizeNode{name: string(md.FullName())}
+	o := proto.MarshalOptions{Deterministic: true}
+	for _, m := range messages {
+		b, err := o.Marshal(m)
+		if err != nil {
+			return err
+		}
+		root.count++
+		root.size += len(b)
+		if err := root.addWire(b, md, pb.types); err != nil {
+			return err
+		}
+	}
+	buf := &bytes.Buffer{}
+	root.print(buf, len(messages))
+	return pb.writeOutput(buf.Bytes())
+}
+
+// addWire adds the sizes of the fields in the wire encoding b of a message
+// described by md to the children of n. Message fields are walked
+// recursively. The elements of packed fields are counted one by one.
+func (n *sizeNode) addWire(b []byte, md protoreflect.MessageDescriptor, types protoregistry.ExtensionTypeResolver) error {
+	for len(b) > 0 {
+		num, typ, tagLen := protowire.ConsumeTag(b)
+		if tagLen < 0 {
+			return fmt.Errorf("%s: %w", n.name, protowire.ParseError(tagLen))
+		}
+		valueLen := protowire.ConsumeFieldValue(num, typ, b[tagLen:])
+		if valueLen < 0 {
+			return fmt.Errorf("%s: %w", n.name, protowire.ParseError(valueLen))
+		}
+		fd := fieldByNumber(md, num, types)
+		child := n.child(fieldName(fd, num))
+		child.size += tagLen + valueLen
+
+		value := b[tagLen : tagLen+valueLen]
+		if fd != nil && fd.IsList() && typ == protowire.BytesType && fd.Kind() != protoreflect.StringKind && fd.Kind() != protoreflect.BytesKind && fd.Message() == nil {
+			v, _ := protowire.ConsumeBytes(value)
+			child.count += packedCount(fd.Kind(), v)
+		} else {
+			child.count++
+		}
+		if fd != nil && fd.Message() != nil {
+			switch typ {
+			case protowire.BytesType:
+				v, _ := protowire.ConsumeBytes(value)
+				if err := child.addWire(v, fd.Message(), types); err != nil {
+					return err
+				}
+			case protowire.StartGroupType:
+				v, _ := protowire.ConsumeGroup(num, value)
+				if err := child.addWire(v, fd.Message(), types); err != nil {
+					return err
+				}
+			}
+		}
+		b = b[tagLen+valueLen:]
+	}
+	return nil
+}
+
+// fieldByNumber returns the field or extension of md with the given
+// number, or nil if it is unknown.
+func fieldByNumber(md protoreflect.MessageDescriptor, num protowire.Number, types protoregistry.ExtensionTypeResolver) protoreflect.FieldDescriptor {
+	if fd := md.Fields().ByNumber(num); fd != nil {
+		return fd
+	}
+	if et, err := types.FindExtensionByNumber(md.FullName(), num); err == nil {
+		return et.TypeDescriptor()
+	}
+	return nil
+}
+
+func fieldName(fd protoreflect.FieldDescriptor, num protowire.Number) string {
+	switch {
+	case fd == nil:
+		return strconv.Itoa(int(num)) + " (unknown)"
+	case fd.IsExtension():
+		return "[" + string(fd.FullName()) + "]"
+	}
+	return string(fd.Name())
+}
+
+func (n *sizeNode) child(name string) *sizeNode {
+	if n.children == nil {
+		n.children = map[string]*sizeNode{}
+	}
+	child, ok := n.children[name]
+	if !ok {
+		child = &sizeNode{name: name}
+		n.children[name] = child
+	}
+	return child
+}
+
+// sortedChildren returns the children of n, largest first.
+func (n *sizeNode) sortedChildren() []*sizeNode {
+	result := make([]*sizeNode, 0, len(n.children))
+	for _, child := range n.children {
+		result = append(result, child)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].size != result[j].size {
+			return result[i].size > result[j].size
+		}
+		return result[i].name < result[j].name
+	})
+	return result
+}
+
+// print writes the size tree below n with the share of the total size of
+// each field. Average sizes per message are added for a stream of several
+// messages.
+func (n *sizeNode) print(w io.Writer, messages int) {
+	type line struct {
+		name string
+		node *sizeNode
+	}
+	var lines []line
+	var walk func(n *sizeNode, indent string)
+	walk = func(n *sizeNode, indent string) {
+		lines = append(lines, line{indent + n.name, n})
+		for _, child := range n.sortedChildren() {
+			walk(child, indent+"  ")
+		}
+	}
+	walk(n, "")
+
+	width := len("FIELD")
+	for _, l := range lines {
+		if len(l.name) > width {
+			width = len(l.name)
+		}
+	}
+	fmt.Fprintf(w, "%-*s %8s %10s %7s", width, "FIELD", "COUNT", "BYTES", "%")
+	if messages > 1 {
+		fmt.Fprintf(w, " %10s", "AVG")
+	}
+	fmt.Fprintln(w)
+	for _, l := range lines {
+		percent := 0.0
+		if n.size > 0 {
+			percent = 100 * float64(l.node.size) / float64(n.size)
+		}
+		fmt.Fprintf(w, "%-*s %8d %10d %6.1f%%", width, l.name, l.node.count, l.node.size, percent)
+		if messages > 1 {
+			fmt.Fprintf(w, " %10.1f", float64(l.node.size)/float64(messages))
+		}
+		fmt.Fprintln(w)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSize(t *testing.T) {
+	tmpDir := t.TempDir()
+	cmd := SizeCmd{
+		Protoset:    newFDS(t, "testdata/pbtest.pb"),
+		Out:         filepath.Join(tmpDir, "size.txt"),
+		MessageType: "Record",
+		In:          `{"id": "abc", "items": [{"name": "x", "count": 3}, {"name": "yy"}], "main": {"name": "m"}}`,
+	}
+	require.NoError(t, cmd.Run())
+	want := `FIELD            COUNT      BYTES       %
+pbtest.Record        1         23  100.0%
+  items              2         13   56.5%
+    name             2          7   30.4%
+    count            1          2    8.7%
+  id                 1          5   21.7%
+  main               1          5   21.7%
+    name             1          3   13.0%
+`
+	requireFileContent(t, want, cmd.Out)
+}
+
+func TestSizeStream(t *testing.T) {
+	tmpDir := t.TempDir()
+	cmd := SizeCmd{
+		Protoset:    newFDS(t, "testdata/pbtest.pb"),
+		Out:         filepath.Join(tmpDir, "size.txt"),
+		MessageType: "BaseMessage",
+		InFormat:    "jsonl",
+		In:          `{"f": "abc"}` + "\n" + `{"f": "a"}` + "\n" + `{}`,
+	}
+	require.NoError(t, cmd.Run())
+	want := `FIELD                 COUNT      BYTES       %        AVG
+pbtest.BaseMessage        3          8  100.0%        2.7
+  f                       2          8  100.0%        2.7
+`
+	requireFileContent(t, want, cmd.Out)
+}
+
+func TestSizeUnknownField(t *testing.T) {
+	tmpDir := t.TempDir()
+	cmd := SizeCmd{
+		Protoset:    newFDS(t, "testdata/pbtest.pb"),
+		Out:         filepath.Join(tmpDir, "size.txt"),
+		MessageType: "BaseMessage",
+		InFormat:    "pb",
+		In:          "\x0a\x01x\x78\x01",
+	}
+	require.NoError(t, cmd.Run())
+	want := `FIELD                 COUNT      BYTES       %
+pbtest.BaseMessage        1          5  100.0%
+  f                       1          3   60.0%
+  15 (unknown)            1          2   40.0%
+`
+	requireFileContent(t, want, cmd.Out)
+}
+
+func TestSizePacked(t *testing.T) {
+	tmpDir := t.TempDir()
+	cmd := SizeCmd{
+		Out:         filepath.Join(tmpDir, "size.txt"),
+		MessageType: "google.protobuf.SourceCodeInfo",
+		In:          `{"location": [{"path": [1, 2, 300], "span": [4]}]}`,
+	}
+	require.NoError(t, cmd.Run())
+	want := `FIELD                             COUNT      BYTES       %
+google.protobuf.SourceCodeInfo        1         11  100.0%
+  location                            1         11  100.0%
+    path                              3          6   54.5%
+    span                              1          3   27.3%
+`
+	requireFileContent(t, want, cmd.Out)
+}
+
+func TestSizeErr(t *testing.T) {
+	cmd := SizeCmd{MessageType: "NoSuchMessage", In: "{}"}
+	require.Error(t, cmd.Run())
+	cmd = SizeCmd{MessageType: "Duration", In: "@nonexistent.json"}
+	require.Error(t, cmd.Run())
+	cmd = SizeCmd{MessageType: "Duration", In: `"bad"`}
+	require.Error(t, cmd.Run())
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// isStream returns true for formats that hold any number of messages
+// rather than a single message.
+func isStream(format string) bool {
+	switch format {
+	case "jsonl", "pbd", "csv", "tsv", "grpc", "grpc-web-text":
+		return true
+	}
+	return false
+}
+
+// isBinary returns true for formats that should not be written to a
+// terminal.
+func isBinary(format string) bool {
+	return format == "pb" || format == "pbd" || format == "grpc"
+}
+
+// convertStream converts a stream of messages, keeping only the messages
+// matching the --where predicate if there is one. The single message
+// formats can be used on either side of the conversion as a stream of one
+// message, and json and txt output can show several messages one after
+// another.
+func (c *PBConfig) convertStream(mt protoreflect.MessageType, in []byte, inFormat string) ([]byte, error) {
+	messages, err := c.decodeStream(mt, in, inFormat)
+	if err != nil {
+		return nil, err
+	}
+	if c.where != nil {
+		messages = filterMessages(messages, c.where)
+	}
+	return c.encodeStream(mt, messages)
+}
+
+func (c *PBConfig) decodeStream(mt protoreflect.MessageType, in []byte, format string) ([]proto.Message, error) {
+	var records [][]byte
+	switch format {
+	case "csv", "tsv":
+		return c.decodeCSV(mt, in, format)
+	case "jsonl":
+		records = splitLines(in)
+		format = "json"
+	case "pbd":
+		var err error
+		if records, err = splitDelimited(in); err != nil {
+			return nil, err
+		}
+		format = "pb"
+	case "grpc-web-text":
+		var err error
+		if in, err = decodeGRPCWebText(in); err != nil {
+			return nil, err
+		}
+		fallthrough
+	case "grpc":
+		var err error
+		if records, err = c.splitFrames(in); err != nil {
+			return nil, err
+		}
+		format = "pb"
+	default:
+		records = [][]byte{in}
+	}
+	unmarshal, err := c.unmarshaler(format, c.types)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode %q input: %w", format, err)
+	}
+	messages := make([]proto.Message, len(records))
+	for i, record := range records {
+		m := mt.New().Interface()
+		if err := unmarshal(record, m); err != nil {
+			return nil, fmt.Errorf("message %d: %w", i+1, err)
+		}
+		messages[i] = m
+	}
+	return messages, nil
+}
+
+func (c *PBConfig) encodeStream(mt protoreflect.MessageType, messages []proto.Message) ([]byte, error) {
+	format := c.outFormat()
+	switch format {
+	case "csv", "tsv":
+		return c.encodeCSV(mt.Descriptor(), messages, format)
+	case "pb":
+		if len(messages) > 1 {
+			return nil, errors.New(`cannot write several messages as "pb", use "pbd"`)
+		}
+	}
+	marshal, err := c.marshaler(c.types)
+	if err != nil {
+		return nil, err
+	}
+	var out []byte
+	for _, m := range messages {
+		b, err := marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b...)
+	}
+	if format == "grpc-web-text" {
+		out = []byte(base64.StdEncoding.EncodeToString(out))
+	}
+	return out, nil
+}
+
+func filterMessages(messages []proto.Message, where predicate) []proto.Message {
+	result := messages[:0]
+	for _, m := range messages {
+		if where(m.ProtoReflect()) {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// splitLines splits JSON Lines input into its records, skipping blank
+// lines.
+func splitLines(b []byte) [][]byte {
+	var result [][]byte
+	for _, line := range bytes.Split(b, []byte("\n")) {
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			result = append(result, line)
+		}
+	}
+	return result
+}
+
+// splitDelimited splits a stream of binary messages, each prefixed by its
+// varint encoded length, into its records.
+func splitDelimited(b []byte) ([][]byte, error) {
+	var result [][]byte
+	for len(b) > 0 {
+		size, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return nil, fmt.Errorf("message %d: invalid length prefix: %w", len(result)+1, protowire.ParseError(n))
+		}
+		b = b[n:]
+		if size > uint64(len(b)) {
+			return nil, fmt.Errorf("message %d: truncated, want %d bytes, have %d", len(result)+1, size, len(b))
+		}
+		result = append(result, b[:size])
+		b = b[size:]
+	}
+	return result, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunStream(t *testing.T) {
+	tmpDir := t.TempDir()
+	fds := newFDS(t, "testdata/pbtest.pb")
+	in := `{"id": "a", "status": "OK"}
+{"id": "b", "latencyMs": "600"}
+
+{"id": "c", "tags": ["x", "y"]}
+`
+
+	// jsonl -> pbd -> jsonl round trip
+	cli := PBConfig{
+		Protoset:    fds,
+		Out:         filepath.Join(tmpDir, "out.pbd"),
+		MessageType: "Record",
+		In:          in,
+		InFormat:    "jsonl",
+	}
+	require.NoError(t, cli.Run())
+
+	cli = PBConfig{
+		Protoset:    fds,
+		Out:         filepath.Join(tmpDir, "out.jsonl"),
+		MessageType: "Record",
+		In:          "@" + filepath.Join(tmpDir, "out.pbd"),
+	}
+	require.NoError(t, cli.Run())
+	b, err := os.ReadFile(cli.Out)
+	require.NoError(t, err)
+	lines := splitLines(b)
+	require.Len(t, lines, 3)
+	require.JSONEq(t, `{"id": "a", "status": "OK"}`, string(lines[0]))
+	require.JSONEq(t, `{"id": "b", "latencyMs": "600"}`, string(lines[1]))
+	require.JSONEq(t, `{"id": "c", "tags": ["x", "y"]}`, string(lines[2]))
+}
+
+func TestRunStreamErr(t *testing.T) {
+	tmpDir := t.TempDir()
+	fds := newFDS(t, "testdata/pbtest.pb")
+
+	tests := map[string]PBConfig{
+		"several messages as pb": {In: `{"id": "a"}` + "\n" + `{"id": "b"}`, InFormat: "jsonl", OutFormat: "pb"},
+		"bad record":             {In: `{"id": "a"}` + "\n" + `{"MISSING": "b"}`, InFormat: "jsonl"},
+		"truncated delimited":    {In: "\x05ab", InFormat: "pbd"},
+		"invalid length prefix":  {In: "\xff", InFormat: "pbd"},
+	}
+	for name, cli := range tests {
+		cli := cli
+		t.Run(name, func(t *testing.T) {
+			cli.Protoset = fds
+			cli.MessageType = "Record"
+			cli.Out = filepath.Join(tmpDir, "out")
+			require.Error(t, cli.Run())
+		})
+	}
+}
@@ -0,0 +1,876 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// predicate is a compiled --where expression.
+type predicate func(protoreflect.Message) bool
+
+// compileWhere parses and type checks a --where expression against a
+// message descriptor. Type errors such as unknown fields, mismatched
+// comparisons or unknown enum value names are reported here rather than
+// when evaluating a message. An empty expression compiles to a nil
+// predicate.
+//
+// The expression language has:
+//   - literals: "string" or 'string', 123, 1.5, true, false, [list, ...]
+//   - field paths: name or name.nested_name, by proto or JSON name
+//   - comparisons: == != < <= > >=, and x in list
+//   - logical operators: && || ! and parentheses
+//   - functions: has(path), size(x), lower(s), upper(s), contains(s, sub),
+//     startsWith(s, prefix), endsWith(s, suffix), matches(s, "regexp")
+//
+// Enum fields compare with the name of an enum value as a string, or with
+// its number.
+func compileWhere(md protoreflect.MessageDescriptor, s string) (predicate, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	p := &whereParser{md: md, lex: &whereLexer{s: s}}
+	p.next()
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid --where expression: %w", err)
+	}
+	if p.tok.kind != tokEOF {
+		return nil, fmt.Errorf("invalid --where expression: unexpected %s at offset %d", p.tok, p.tok.pos)
+	}
+	if e.typ.kind != boolKind {
+		return nil, fmt.Errorf("invalid --where expression: result is %s, not bool", e.typ)
+	}
+	return func(m protoreflect.Message) bool { return e.eval(m).(bool) }, nil
+}
+
+type whereKind int
+
+const (
+	boolKind whereKind = iota
+	intKind
+	uintKind
+	floatKind
+	stringKind
+	bytesKind
+	enumKind
+	messageKind
+	listKind
+	mapKind
+)
+
+// whereType is the type of a --where expression.
+type whereType struct {
+	kind whereKind
+	enum protoreflect.EnumDescriptor
+	elem *whereType
+}
+
+func (t whereType) String() string {
+	switch t.kind {
+	case boolKind:
+		return "bool"
+	case intKind:
+		return "int"
+	case uintKind:
+		return "uint"
+	case floatKind:
+		return "float"
+	case stringKind:
+		return "string"
+	case bytesKind:
+		return "bytes"
+	case enumKind:
+		return string(t.enum.FullName())
+	case messageKind:
+		return "message"
+	case listKind:
+		return "list of " + t.elem.String()
+	}
+	return "map"
+}
+
+func (t whereType) numeric() bool {
+	return t.kind == intKind || t.kind == uintKind || t.kind == floatKind
+}
+
+// whereExpr is a type checked --where expression. Values are evaluated as
+// bool, int64, uint64, float64, string, []byte, protoreflect.EnumNumber,
+// protoreflect.Message, []interface{} and protoreflect.Map.
+type whereExpr struct {
+	typ  whereType
+	eval func(protoreflect.Message) interface{}
+
+	// path is set for field path expressions.
+	path []protoreflect.FieldDescriptor
+	// literal is set for literal expressions so they can be converted to
+	// the type they are compared with.
+	literal interface{}
+	// elems is set for list literals.
+	elems []*whereExpr
+}
+
+func literalExpr(typ whereType, v interface{}) *whereExpr {
+	return &whereExpr{typ: typ, literal: v, eval: func(protoreflect.Message) interface{} { return v }}
+}
+
+type whereParser struct {
+	md  protoreflect.MessageDescriptor
+	lex *whereLexer
+	tok whereToken
+	err error
+}
+
+func (p *whereParser) next() {
+	if p.err == nil {
+		p.tok, p.err = p.lex.next()
+	}
+}
+
+func (p *whereParser) is(op string) bool {
+	return p.tok.kind == tokOp && p.tok.text == op
+}
+
+func (p *whereParser) expect(op string) error {
+	if p.err != nil {
+		return p.err
+	}
+	if !p.is(op) {
+		return fmt.Errorf("expected %q, got %s at offset %d", op, p.tok, p.tok.pos)
+	}
+	p.next()
+	return p.err
+}
+
+func (p *whereParser) parseOr() (*whereExpr, error) {
+	return p.parseLogical("||", p.parseAnd)
+}
+
+func (p *whereParser) parseAnd() (*whereExpr, error) {
+	return p.parseLogical("&&", p.parseNot)
+}
+
+func (p *whereParser) parseLogical(op string, parseOperand func() (*whereExpr, error)) (*whereExpr, error) {
+	left, err := parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for p.is(op) {
+		p.next()
+		right, err := parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if left.typ.kind != boolKind || right.typ.kind != boolKind {
+			return nil, fmt.Errorf("operands of %s must be bool, not %s and %s", op, left.typ, right.typ)
+		}
+		l, r := left.eval, right.eval
+		eval := func(m protoreflect.Message) interface{} { return l(m).(bool) && r(m).(bool) }
+		if op == "||" {
+			eval = func(m protoreflect.Message) interface{} { return l(m).(bool) || r(m).(bool) }
+		}
+		left = &whereExpr{typ: whereType{kind: boolKind}, eval: eval}
+	}
+	return left, p.err
+}
+
+func (p *whereParser) parseNot() (*whereExpr, error) {
+	if !p.is("!") {
+		return p.parseComparison()
+	}
+	p.next()
+	e, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	if e.typ.kind != boolKind {
+		return nil, fmt.Errorf("operand of ! must be bool, not %s", e.typ)
+	}
+	return &whereExpr{typ: e.typ, eval: func(m protoreflect.Message) interface{} { return !e.eval(m).(bool) }}, nil
+}
+
+var comparisons = map[string]func(int) bool{
+	"==": func(c int) bool { return c == 0 },
+	"!=": func(c int) bool { return c != 0 },
+	"<":  func(c int) bool { return c < 0 },
+	"<=": func(c int) bool { return c <= 0 },
+	">":  func(c int) bool { return c > 0 },
+	">=": func(c int) bool { return c >= 0 },
+}
+
+func (p *whereParser) parseComparison() (*whereExpr, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokIdent && p.tok.text == "in" {
+		p.next()
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return inExpr(left, right)
+	}
+	cmp, ok := comparisons[p.tok.text]
+	if p.tok.kind != tokOp || !ok {
+		return left, p.err
+	}
+	op := p.tok.text
+	p.next()
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if left, right, err = coercePair(left, right); err != nil {
+		return nil, err
+	}
+	if !comparable(left.typ, right.typ) {
+		return nil, fmt.Errorf("cannot compare %s %s %s", left.typ, op, right.typ)
+	}
+	if op != "==" && op != "!=" && (left.typ.kind == boolKind || left.typ.kind == enumKind) {
+		return nil, fmt.Errorf("cannot order %s values with %s", left.typ, op)
+	}
+	l, r := left.eval, right.eval
+	return &whereExpr{
+		typ:  whereType{kind: boolKind},
+		eval: func(m protoreflect.Message) interface{} { return cmp(compareValues(l(m), r(m))) },
+	}, nil
+}
+
+func inExpr(left, right *whereExpr) (*whereExpr, error) {
+	if right.typ.kind != listKind {
+		return nil, fmt.Errorf("right operand of in must be a list, not %s", right.typ)
+	}
+	var err error
+	if right.elems != nil {
+		if right, err = coerceList(right, left.typ); err != nil {
+			return nil, err
+		}
+	} else if left, err = coerce(left, *right.typ.elem); err != nil {
+		return nil, err
+	}
+	if !comparable(left.typ, *right.typ.elem) {
+		return nil, fmt.Errorf("cannot find %s in %s", left.typ, right.typ)
+	}
+	l, r := left.eval, right.eval
+	return &whereExpr{
+		typ: whereType{kind: boolKind},
+		eval: func(m protoreflect.Message) interface{} {
+			v := l(m)
+			for _, elem := range r(m).([]interface{}) {
+				if compareValues(v, elem) == 0 {
+					return true
+				}
+			}
+			return false
+		},
+	}, nil
+}
+
+func (p *whereParser) parsePrimary() (*whereExpr, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	tok := p.tok
+	switch tok.kind {
+	case tokString:
+		p.next()
+		return literalExpr(whereType{kind: stringKind}, tok.value), p.err
+	case tokInt:
+		p.next()
+		return literalExpr(whereType{kind: intKind}, tok.value), p.err
+	case tokFloat:
+		p.next()
+		return literalExpr(whereType{kind: floatKind}, tok.value), p.err
+	case tokIdent:
+		p.next()
+		switch {
+		case tok.text == "true" || tok.text == "false":
+			return literalExpr(whereType{kind: boolKind}, tok.text == "true"), p.err
+		case p.is("("):
+			return p.parseCall(tok)
+		}
+		return p.parsePath(tok)
+	case tokOp:
+		switch tok.text {
+		case "(":
+			p.next()
+			e, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expect(")")
+		case "[":
+			p.next()
+			return p.parseList()
+		case "-":
+			p.next()
+			e, err := p.parsePrimary()
+			if err != nil {
+				return nil, err
+			}
+			switch v := e.literal.(type) {
+			case int64:
+				return literalExpr(e.typ, -v), nil
+			case float64:
+				return literalExpr(e.typ, -v), nil
+			}
+			return nil, fmt.Errorf("unary - only applies to number literals at offset %d", tok.pos)
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s at offset %d", tok, tok.pos)
+}
+
+func (p *whereParser) parseList() (*whereExpr, error) {
+	elems := []*whereExpr{}
+	for !p.is("]") {
+		e, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, e)
+		if !p.is(",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expect("]"); err != nil {
+		return nil, err
+	}
+	return listExpr(elems)
+}
+
+func listExpr(elems []*whereExpr) (*whereExpr, error) {
+	elem := whereType{kind: stringKind}
+	if len(elems) > 0 {
+		elem = elems[0].typ
+	}
+	for _, e := range elems {
+		if !comparable(e.typ, elem) {
+			return nil, fmt.Errorf("list mixes %s and %s elements", elem, e.typ)
+		}
+	}
+	return &whereExpr{
+		typ:   whereType{kind: listKind, elem: &elem},
+		elems: elems,
+		eval: func(m protoreflect.Message) interface{} {
+			result := make([]interface{}, len(elems))
+			for i, e := range elems {
+				result[i] = e.eval(m)
+			}
+			return result
+		},
+	}, nil
+}
+
+func (p *whereParser) parsePath(tok whereToken) (*whereExpr, error) {
+	names := []string{tok.text}
+	for p.is(".") {
+		p.next()
+		if p.tok.kind != tokIdent {
+			return nil, fmt.Errorf("expected field name, got %s at offset %d", p.tok, p.tok.pos)
+		}
+		names = append(names, p.tok.text)
+		p.next()
+	}
+	var path []protoreflect.FieldDescriptor
+	md := p.md
+	for _, name := range names {
+		if md == nil {
+			return nil, fmt.Errorf("invalid field path %s: %s is not a singular message field", strings.Join(names, "."), columnName(path))
+		}
+		fd := md.Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			fd = md.Fields().ByJSONName(name)
+		}
+		if fd == nil {
+			return nil, fmt.Errorf("no field %s in %s", name, md.FullName())
+		}
+		path = append(path, fd)
+		md = nil
+		if fd.Message() != nil && !fd.IsList() && !fd.IsMap() {
+			md = fd.Message()
+		}
+	}
+	fd := path[len(path)-1]
+	elem := fieldType(fd)
+	typ := elem
+	if fd.IsList() {
+		typ = whereType{kind: listKind, elem: &elem}
+	} else if fd.IsMap() {
+		typ = whereType{kind: mapKind}
+	}
+	return &whereExpr{
+		typ:  typ,
+		path: path,
+		eval: func(m protoreflect.Message) interface{} {
+			for _, fd := range path[:len(path)-1] {
+				m = m.Get(fd).Message()
+			}
+			return fieldValue(fd, m.Get(fd))
+		},
+	}, p.err
+}
+
+func fieldType(fd protoreflect.FieldDescriptor) whereType {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return whereType{kind: boolKind}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return whereType{kind: intKind}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return whereType{kind: uintKind}
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return whereType{kind: floatKind}
+	case protoreflect.StringKind:
+		return whereType{kind: stringKind}
+	case protoreflect.BytesKind:
+		return whereType{kind: bytesKind}
+	case protoreflect.EnumKind:
+		return whereType{kind: enumKind, enum: fd.Enum()}
+	}
+	return whereType{kind: messageKind}
+}
+
+// fieldValue converts a field value to its --where representation.
+func fieldValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) interface{} {
+	if fd.IsList() {
+		list := v.List()
+		result := make([]interface{}, list.Len())
+		for i := range result {
+			result[i] = scalarValue(fd, list.Get(i))
+		}
+		return result
+	}
+	if fd.IsMap() {
+		return v.Map()
+	}
+	return scalarValue(fd, v)
+}
+
+func scalarValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) interface{} {
+	switch fieldType(fd).kind {
+	case intKind:
+		return v.Int()
+	case uintKind:
+		return v.Uint()
+	case floatKind:
+		return v.Float()
+	case enumKind:
+		return v.Enum()
+	case messageKind:
+		return v.Message()
+	}
+	return v.Interface()
+}
+
+type whereFunc struct {
+	args  []whereKind
+	typ   whereType
+	apply func(args []interface{}) interface{}
+}
+
+var whereFuncs = map[string]whereFunc{
+	"lower": {[]whereKind{stringKind}, whereType{kind: stringKind}, func(a []interface{}) interface{} {
+		return strings.ToLower(a[0].(string))
+	}},
+	"upper": {[]whereKind{stringKind}, whereType{kind: stringKind}, func(a []interface{}) interface{} {
+		return strings.ToUpper(a[0].(string))
+	}},
+	"contains": {[]whereKind{stringKind, stringKind}, whereType{kind: boolKind}, func(a []interface{}) interface{} {
+		return strings.Contains(a[0].(string), a[1].(string))
+	}},
+	"startsWith": {[]whereKind{stringKind, stringKind}, whereType{kind: boolKind}, func(a []interface{}) interface{} {
+		return strings.HasPrefix(a[0].(string), a[1].(string))
+	}},
+	"endsWith": {[]whereKind{stringKind, stringKind}, whereType{kind: boolKind}, func(a []interface{}) interface{} {
+		return strings.HasSuffix(a[0].(string), a[1].(string))
+	}},
+}
+
+func (p *whereParser) parseCall(name whereToken) (*whereExpr, error) {
+	p.next() // (
+	var args []*whereExpr
+	for !p.is(")") {
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, e)
+		if !p.is(",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	switch name.text {
+	case "has":
+		return hasExpr(args)
+	case "size":
+		return sizeExpr(args)
+	case "matches":
+		return matchesExpr(args)
+	}
+	fn, ok := whereFuncs[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s at offset %d", name.text, name.pos)
+	}
+	if len(args) != len(fn.args) {
+		return nil, fmt.Errorf("%s takes %d arguments, not %d", name.text, len(fn.args), len(args))
+	}
+	for i, arg := range args {
+		if arg.typ.kind != fn.args[i] {
+			return nil, fmt.Errorf("argument %d of %s must be %s, not %s", i+1, name.text, whereType{kind: fn.args[i]}, arg.typ)
+		}
+	}
+	return &whereExpr{
+		typ: fn.typ,
+		eval: func(m protoreflect.Message) interface{} {
+			values := make([]interface{}, len(args))
+			for i, arg := range args {
+				values[i] = arg.eval(m)
+			}
+			return fn.apply(values)
+		},
+	}, nil
+}
+
+// hasExpr returns true if the field at the end of a field path is set.
+// Unset intermediate messages make has false.
+func hasExpr(args []*whereExpr) (*whereExpr, error) {
+	if len(args) != 1 || args[0].path == nil {
+		return nil, fmt.Errorf("has takes a single field path argument")
+	}
+	path := args[0].path
+	return &whereExpr{
+		typ: whereType{kind: boolKind},
+		eval: func(m protoreflect.Message) interface{} {
+			for _, fd := range path[:len(path)-1] {
+				if !m.Has(fd) {
+					return false
+				}
+				m = m.Get(fd).Message()
+			}
+			return m.Has(path[len(path)-1])
+		},
+	}, nil
+}
+
+func sizeExpr(args []*whereExpr) (*whereExpr, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("size takes 1 argument, not %d", len(args))
+	}
+	arg := args[0]
+	switch arg.typ.kind {
+	case stringKind, bytesKind, listKind, mapKind:
+	default:
+		return nil, fmt.Errorf("size argument must be a string, bytes, list or map, not %s", arg.typ)
+	}
+	return &whereExpr{
+		typ: whereType{kind: intKind},
+		eval: func(m protoreflect.Message) interface{} {
+			switch v := arg.eval(m).(type) {
+			case string:
+				return int64(len(v))
+			case []byte:
+				return int64(len(v))
+			case []interface{}:
+				return int64(len(v))
+			case protoreflect.Map:
+				return int64(v.Len())
+			}
+			return int64(0)
+		},
+	}, nil
+}
+
+// matchesExpr matches a string against a regular expression literal which
+// is compiled along with the --where expression.
+func matchesExpr(args []*whereExpr) (*whereExpr, error) {
+	if len(args) != 2 || args[0].typ.kind != stringKind {
+		return nil, fmt.Errorf("matches takes a string and a regular expression literal")
+	}
+	pattern, ok := args[1].literal.(string)
+	if !ok {
+		return nil, fmt.Errorf("matches takes a string and a regular expression literal")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	s := args[0].eval
+	return &whereExpr{
+		typ:  whereType{kind: boolKind},
+		eval: func(m protoreflect.Message) interface{} { return re.MatchString(s(m).(string)) },
+	}, nil
+}
+
+// coercePair converts a literal on either side of a comparison to the
+// type of the other side.
+func coercePair(left, right *whereExpr) (*whereExpr, *whereExpr, error) {
+	var err error
+	if left.literal != nil {
+		left, err = coerce(left, right.typ)
+	} else {
+		right, err = coerce(right, left.typ)
+	}
+	return left, right, err
+}
+
+// coerce converts a string or int literal to an enum value and a string
+// literal to bytes. Other expressions are returned unchanged.
+func coerce(e *whereExpr, typ whereType) (*whereExpr, error) {
+	switch v := e.literal.(type) {
+	case string:
+		switch typ.kind {
+		case enumKind:
+			ev := typ.enum.Values().ByName(protoreflect.Name(v))
+			if ev == nil {
+				return nil, fmt.Errorf("unknown %s value %q", typ.enum.FullName(), v)
+			}
+			return literalExpr(typ, ev.Number()), nil
+		case bytesKind:
+			return literalExpr(typ, []byte(v)), nil
+		}
+	case int64:
+		if typ.kind == enumKind {
+			return literalExpr(typ, protoreflect.EnumNumber(v)), nil
+		}
+	}
+	return e, nil
+}
+
+func coerceList(list *whereExpr, elem whereType) (*whereExpr, error) {
+	elems := make([]*whereExpr, len(list.elems))
+	for i, e := range list.elems {
+		var err error
+		if elems[i], err = coerce(e, elem); err != nil {
+			return nil, err
+		}
+	}
+	if len(elems) == 0 {
+		return &whereExpr{typ: whereType{kind: listKind, elem: &elem}, elems: elems, eval: list.eval}, nil
+	}
+	return listExpr(elems)
+}
+
+func comparable(a, b whereType) bool {
+	switch {
+	case a.numeric() && b.numeric():
+		return true
+	case a.kind == enumKind && b.kind == enumKind:
+		return a.enum.FullName() == b.enum.FullName()
+	case a.kind == listKind, a.kind == mapKind, a.kind == messageKind:
+		return false
+	}
+	return a.kind == b.kind
+}
+
+// compareValues compares two type checked values, returning -1, 0 or 1.
+func compareValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case bool:
+		if a == b.(bool) {
+			return 0
+		}
+		return 1
+	case string:
+		return strings.Compare(a, b.(string))
+	case []byte:
+		return bytes.Compare(a, b.([]byte))
+	case protoreflect.EnumNumber:
+		return compareInts(int64(a), int64(b.(protoreflect.EnumNumber)))
+	}
+	return compareNumbers(a, b)
+}
+
+func compareNumbers(a, b interface{}) int {
+	switch a := a.(type) {
+	case float64:
+		return compareFloats(a, toFloat(b))
+	case int64:
+		switch b := b.(type) {
+		case int64:
+			return compareInts(a, b)
+		case uint64:
+			if a < 0 {
+				return -1
+			}
+			return compareUints(uint64(a), b)
+		}
+	case uint64:
+		switch b := b.(type) {
+		case uint64:
+			return compareUints(a, b)
+		case int64:
+			return -compareNumbers(b, a)
+		}
+	}
+	return compareFloats(toFloat(a), toFloat(b))
+}
+
+func toFloat(v interface{}) float64 {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	}
+	return v.(float64)
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareUints(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokInt
+	tokFloat
+	tokOp
+)
+
+type whereToken struct {
+	kind  tokenKind
+	text  string
+	value interface{}
+	pos   int
+}
+
+func (t whereToken) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+type whereLexer struct {
+	s   string
+	pos int
+}
+
+var whereOps = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ",", ".", "-"}
+
+func (l *whereLexer) next() (whereToken, error) {
+	for l.pos < len(l.s) && unicode.IsSpace(rune(l.s[l.pos])) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos == len(l.s) {
+		return whereToken{kind: tokEOF, pos: start}, nil
+	}
+	c := l.s[l.pos]
+	switch {
+	case c == '"' || c == '\'':
+		return l.string(c)
+	case c >= '0' && c <= '9':
+		return l.number()
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for l.pos < len(l.s) && (l.s[l.pos] == '_' || unicode.IsLetter(rune(l.s[l.pos])) || unicode.IsDigit(rune(l.s[l.pos]))) {
+			l.pos++
+		}
+		return whereToken{kind: tokIdent, text: l.s[start:l.pos], pos: start}, nil
+	}
+	for _, op := range whereOps {
+		if strings.HasPrefix(l.s[l.pos:], op) {
+			l.pos += len(op)
+			return whereToken{kind: tokOp, text: op, pos: start}, nil
+		}
+	}
+	return whereToken{}, fmt.Errorf("unexpected character %q at offset %d", c, start)
+}
+
+func (l *whereLexer) string(quote byte) (whereToken, error) {
+	start := l.pos
+	sb := strings.Builder{}
+	for l.pos++; l.pos < len(l.s) && l.s[l.pos] != quote; {
+		r, multibyte, tail, err := strconv.UnquoteChar(l.s[l.pos:], quote)
+		if err != nil {
+			return whereToken{}, fmt.Errorf("invalid string escape at offset %d", l.pos)
+		}
+		if multibyte {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteByte(byte(r))
+		}
+		l.pos = len(l.s) - len(tail)
+	}
+	if l.pos >= len(l.s) {
+		return whereToken{}, fmt.Errorf("unterminated string at offset %d", start)
+	}
+	l.pos++
+	return whereToken{kind: tokString, text: l.s[start:l.pos], value: sb.String(), pos: start}, nil
+}
+
+func (l *whereLexer) number() (whereToken, error) {
+	start := l.pos
+	isFloat := false
+	for l.pos < len(l.s) {
+		c := l.s[l.pos]
+		switch {
+		case c >= '0' && c <= '9':
+		case c == '.' || c == 'e' || c == 'E':
+			isFloat = true
+		case (c == '+' || c == '-') && (l.s[l.pos-1] == 'e' || l.s[l.pos-1] == 'E'):
+		default:
+			return l.numberToken(start, isFloat)
+		}
+		l.pos++
+	}
+	return l.numberToken(start, isFloat)
+}
+
+func (l *whereLexer) numberToken(start int, isFloat bool) (whereToken, error) {
+	text := l.s[start:l.pos]
+	if isFloat {
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return whereToken{}, fmt.Errorf("invalid number %s at offset %d", text, start)
+		}
+		return whereToken{kind: tokFloat, text: text, value: f, pos: start}, nil
+	}
+	i, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return whereToken{}, fmt.Errorf("invalid number %s at offset %d", text, start)
+	}
+	return whereToken{kind: tokInt, text: text, value: i, pos: start}, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"foxygo.at/protog/registry"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func newRecord(t *testing.T, js string) protoreflect.Message {
+	t.Helper()
+	files, err := registry.NewFiles(newFDS(t, "testdata/pbtest.pb"))
+	require.NoError(t, err)
+	mt, err := files.FindMessageByName("pbtest.Record")
+	require.NoError(t, err)
+	m := mt.New()
+	require.NoError(t, protojson.Unmarshal([]byte(js), m.Interface()))
+	return m
+}
+
+func TestWhere(t *testing.T) {
+	m := newRecord(t, `{"id": "Abc", "status": "FAILED", "latencyMs": "600", "tags": ["x", "y"], "main": {"name": "m"}, "score": 0.5, "labels": {"k": "v"}, "data": "AAE="}`)
+	tests := map[string]bool{
+		`status == "FAILED" && latency_ms > 500`:    true,
+		`status == "FAILED" && latencyMs > 600`:     false,
+		`status != 'OK'`:                            true,
+		`status == 2`:                               true,
+		`status in ["OK", "FAILED"]`:                true,
+		`status in []`:                              false,
+		`"y" in tags`:                               true,
+		`"z" in tags`:                               false,
+		`id in ["a", "b"] || !(score < 1)`:          false,
+		`latency_ms >= 600 && latency_ms <= 600.0`:  true,
+		`latency_ms > -1 && score > -0.5e1`:         true,
+		`main.name == "m" && main.count == 0`:       true,
+		`has(main) && has(main.name)`:               true,
+		`has(parent) || has(parent.id)`:             false,
+		`has(main.count)`:                           false,
+		`size(tags) == 2 && size(labels) == 1`:      true,
+		`size(id) == 3 && size(data) == 2`:          true,
+		`lower(id) == "abc" && upper(id) == "ABC"`:  true,
+		`contains(id, "b") && startsWith(id, "A")`:  true,
+		`endsWith(id, "c") && matches(id, "^A.c$")`: true,
+		`data == "\x00\x01"`:                        true,
+		`ok == false && ok != true`:                 true,
+		`"a\"b" == 'a"b'`:                           true,
+	}
+	for expr, want := range tests {
+		expr, want := expr, want
+		t.Run(expr, func(t *testing.T) {
+			where, err := compileWhere(m.Descriptor(), expr)
+			require.NoError(t, err)
+			require.Equal(t, want, where(m))
+		})
+	}
+}
+
+func TestWhereErr(t *testing.T) {
+	md := newRecord(t, `{}`).Descriptor()
+	tests := []string{
+		`nope == 1`,
+		`id == 1`,
+		`status == "NOPE"`,
+		`status < "OK"`,
+		`ok > true`,
+		`id`,
+		`id == "a" &&`,
+		`id == "a" && latency_ms`,
+		`!id`,
+		`id in "a"`,
+		`id in [1, "a"]`,
+		`latency_ms in tags`,
+		`tags == tags`,
+		`has("a")`,
+		`size(ok)`,
+		`size()`,
+		`lower(1)`,
+		`lower()`,
+		`nope(id)`,
+		`matches(id, "(")`,
+		`matches(id, id)`,
+		`-id == 1`,
+		`id.name == "a"`,
+		`items.name == "a"`,
+		`main. == "a"`,
+		`id == "unterminated`,
+		`id == "\q"`,
+		`id == 1.2.3`,
+		`id == 99999999999999999999`,
+		`id == "a" )`,
+		`(id == "a"`,
+		`id # "a"`,
+	}
+	for _, expr := range tests {
+		expr := expr
+		t.Run(expr, func(t *testing.T) {
+			_, err := compileWhere(md, expr)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestRunWhere(t *testing.T) {
+	tmpDir := t.TempDir()
+	cli := PBConfig{
+		Protoset:    newFDS(t, "testdata/pbtest.pb"),
+		Out:         filepath.Join(tmpDir, "out.jsonl"),
+		MessageType: "Record",
+		InFormat:    "jsonl",
+		In:          `{"id": "a", "status": "FAILED", "latencyMs": 800}` + "\n" + `{"id": "b", "status": "OK", "latencyMs": 900}` + "\n" + `{"id": "c", "status": "FAILED"}`,
+		Where:       `status == "FAILED" && latency_ms > 500`,
+	}
+	require.NoError(t, cli.Run())
+	b, err := os.ReadFile(cli.Out)
+	require.NoError(t, err)
+	lines := splitLines(b)
+	require.Len(t, lines, 1)
+	require.JSONEq(t, `{"id": "a", "status": "FAILED", "latencyMs": "800"}`, string(lines[0]))
+
+	cli.Where = `status == "UNKNOWN"`
+	require.Error(t, cli.Run())
+}
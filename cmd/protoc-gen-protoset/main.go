@@ -0,0 +1,171 @@
+// protoc-gen-protoset is a protoc plugin that writes the files of a protoc
+// or buf invocation as a protoset: a FileDescriptorSet including imports
+// and source info. It can also write the CodeGeneratorRequest it receives
+// as JSON for debugging plugins.
+//
+// Plugin options are passed as a comma separated list of key=value pairs,
+// e.g. --protoset_opt=name=api.pb,request=request.json:
+//
+//	name:        file name of the protoset (default "protoset.pb")
+//	imports:     include imported files (default true)
+//	source_info: include source code info (default true)
+//	request:     file name for a JSON dump of the CodeGeneratorRequest
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+var (
+	// version vars set by goreleaser
+	version = "tip"
+	commit  = "HEAD"
+	date    = "now"
+)
+
+type options struct {
+	name       string
+	imports    bool
+	sourceInfo bool
+	request    string
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		if os.Args[1] == "--version" {
+			fmt.Printf("%s (%s on %s)\n", version, commit, date)
+			return
+		}
+		fmt.Fprintln(os.Stderr, "protoc-gen-protoset is a protoc plugin and takes no arguments. Use it with protoc --protoset_out=DIR")
+		os.Exit(1)
+	}
+	if err := run(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "protoc-gen-protoset:", err)
+		os.Exit(1)
+	}
+}
+
+func run(r io.Reader, w io.Writer) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	req := &pluginpb.CodeGeneratorRequest{}
+	if err := proto.Unmarshal(b, req); err != nil {
+		return fmt.Errorf("cannot decode CodeGeneratorRequest: %w", err)
+	}
+	resp := generate(req)
+	if b, err = proto.Marshal(resp); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// generate returns a response with the protoset file and optionally the
+// JSON request dump. Errors are reported to protoc in the response.
+func generate(req *pluginpb.CodeGeneratorRequest) *pluginpb.CodeGeneratorResponse {
+	resp := &pluginpb.CodeGeneratorResponse{
+		SupportedFeatures: proto.Uint64(uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)),
+	}
+	opts, err := parseOptions(req.GetParameter())
+	if err != nil {
+		resp.Error = proto.String(err.Error())
+		return resp
+	}
+
+	b, err := proto.Marshal(protoset(req, opts))
+	if err != nil {
+		resp.Error = proto.String(err.Error())
+		return resp
+	}
+	resp.File = append(resp.File, &pluginpb.CodeGeneratorResponse_File{
+		Name:    proto.String(opts.name),
+		Content: proto.String(string(b)),
+	})
+
+	if opts.request != "" {
+		b, err := protojson.MarshalOptions{Multiline: true}.Marshal(req)
+		if err != nil {
+			resp.Error = proto.String(err.Error())
+			return resp
+		}
+		resp.File = append(resp.File, &pluginpb.CodeGeneratorResponse_File{
+			Name:    proto.String(opts.request),
+			Content: proto.String(string(b) + "\n"),
+		})
+	}
+	return resp
+}
+
+// protoset returns the files of the request as a FileDescriptorSet.
+// protoc sends all files in dependency order, with source info only on the
+// files to generate.
+func protoset(req *pluginpb.CodeGeneratorRequest, opts options) *descriptorpb.FileDescriptorSet {
+	generate := map[string]bool{}
+	for _, name := range req.GetFileToGenerate() {
+		generate[name] = true
+	}
+	fds := &descriptorpb.FileDescriptorSet{}
+	for _, f := range req.GetProtoFile() {
+		if !opts.imports && !generate[f.GetName()] {
+			continue
+		}
+		if !opts.sourceInfo && f.SourceCodeInfo != nil {
+			f = proto.Clone(f).(*descriptorpb.FileDescriptorProto)
+			f.SourceCodeInfo = nil
+		}
+		fds.File = append(fds.File, f)
+	}
+	return fds
+}
+
+func parseOptions(parameter string) (options, error) {
+	opts := options{name: "protoset.pb", imports: true, sourceInfo: true}
+	if parameter == "" {
+		return opts, nil
+	}
+	for _, param := range strings.Split(parameter, ",") {
+		key, value := param, ""
+		if i := strings.IndexByte(param, '='); i >= 0 {
+			key, value = param[:i], param[i+1:]
+		}
+		var err error
+		switch key {
+		case "name":
+			opts.name = value
+		case "request":
+			opts.request = value
+		case "imports":
+			opts.imports, err = parseBool(value)
+		case "source_info":
+			opts.sourceInfo, err = parseBool(value)
+		default:
+			return opts, fmt.Errorf("unknown option %q", key)
+		}
+		if err != nil {
+			return opts, fmt.Errorf("invalid value for option %q: %w", key, err)
+		}
+	}
+	if opts.name == "" {
+		return opts, fmt.Errorf("option name must not be empty")
+	}
+	return opts, nil
+}
+
+// parseBool parses an option value, treating a bare option as true.
+func parseBool(value string) (bool, error) {
+	if value == "" {
+		return true, nil
+	}
+	return strconv.ParseBool(value)
+}
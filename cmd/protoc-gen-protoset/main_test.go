@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func newRequest(t *testing.T, parameter string) *pluginpb.CodeGeneratorRequest {
+	t.Helper()
+	b, err := os.ReadFile("../pb/testdata/pbtest.pb")
+	require.NoError(t, err)
+	fds := &descriptorpb.FileDescriptorSet{}
+	require.NoError(t, proto.Unmarshal(b, fds))
+	// protoc only sends source info for the files to generate
+	last := fds.File[len(fds.File)-1]
+	last.SourceCodeInfo = &descriptorpb.SourceCodeInfo{
+		Location: []*descriptorpb.SourceCodeInfo_Location{{Path: []int32{4, 0}, Span: []int32{7, 0, 9, 1}}},
+	}
+	return &pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{last.GetName()},
+		Parameter:      proto.String(parameter),
+		ProtoFile:      fds.File,
+	}
+}
+
+func runRequest(t *testing.T, req *pluginpb.CodeGeneratorRequest) *pluginpb.CodeGeneratorResponse {
+	t.Helper()
+	b, err := proto.Marshal(req)
+	require.NoError(t, err)
+	out := &bytes.Buffer{}
+	require.NoError(t, run(bytes.NewReader(b), out))
+	resp := &pluginpb.CodeGeneratorResponse{}
+	require.NoError(t, proto.Unmarshal(out.Bytes(), resp))
+	return resp
+}
+
+func requireProtoset(t *testing.T, file *pluginpb.CodeGeneratorResponse_File) *descriptorpb.FileDescriptorSet {
+	t.Helper()
+	fds := &descriptorpb.FileDescriptorSet{}
+	require.NoError(t, proto.Unmarshal([]byte(file.GetContent()), fds))
+	_, err := protodesc.NewFiles(fds)
+	require.NoError(t, err)
+	return fds
+}
+
+func TestProtoset(t *testing.T) {
+	req := newRequest(t, "")
+	resp := runRequest(t, req)
+	require.Empty(t, resp.GetError())
+	require.Len(t, resp.File, 1)
+	require.Equal(t, "protoset.pb", resp.File[0].GetName())
+	fds := requireProtoset(t, resp.File[0])
+	require.True(t, proto.Equal(&descriptorpb.FileDescriptorSet{File: req.ProtoFile}, fds))
+	last := fds.File[len(fds.File)-1]
+	require.Equal(t, "pbtest.proto", last.GetName())
+	require.NotNil(t, last.SourceCodeInfo)
+}
+
+func TestProtosetOptions(t *testing.T) {
+	req := newRequest(t, "name=out/api.pb,imports=false,source_info=false,request=req.json")
+	resp := runRequest(t, req)
+	require.Empty(t, resp.GetError())
+	require.Len(t, resp.File, 2)
+	require.Equal(t, "out/api.pb", resp.File[0].GetName())
+	fds := &descriptorpb.FileDescriptorSet{}
+	require.NoError(t, proto.Unmarshal([]byte(resp.File[0].GetContent()), fds))
+	require.Len(t, fds.File, 1)
+	require.Equal(t, "pbtest.proto", fds.File[0].GetName())
+	require.Nil(t, fds.File[0].SourceCodeInfo)
+	// the request is not modified by stripping source info
+	require.NotNil(t, req.ProtoFile[len(req.ProtoFile)-1].SourceCodeInfo)
+
+	require.Equal(t, "req.json", resp.File[1].GetName())
+	got := &pluginpb.CodeGeneratorRequest{}
+	require.NoError(t, protojson.Unmarshal([]byte(resp.File[1].GetContent()), got))
+	require.True(t, proto.Equal(req, got))
+}
+
+func TestProtosetOptionsErr(t *testing.T) {
+	for _, parameter := range []string{"unknown=1", "imports=maybe", "name="} {
+		resp := runRequest(t, newRequest(t, parameter))
+		require.NotEmpty(t, resp.GetError(), parameter)
+		require.Empty(t, resp.File)
+	}
+	opts, err := parseOptions("imports,source_info")
+	require.NoError(t, err)
+	require.True(t, opts.imports)
+	require.True(t, opts.sourceInfo)
+}
+
+func TestRunErr(t *testing.T) {
+	require.Error(t, run(bytes.NewReader([]byte("\xff")), &bytes.Buffer{}))
+}
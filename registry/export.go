@@ -0,0 +1,88 @@
+package registry
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// FileDescriptorSetOptions select the files of a Files exported with
+// FileDescriptorSet and how they are exported.
+type FileDescriptorSetOptions struct {
+	// Files and Symbols select the files with the given paths and the
+	// files defining the given full names, with their transitive
+	// dependencies. If both are empty, all files are exported.
+	Files   []string
+	Symbols []protoreflect.FullName
+
+	// SourceInfo keeps the SourceCodeInfo of the files, which is stripped
+	// by default.
+	SourceInfo bool
+
+	// ExcludeWellKnownTypes leaves out the files of the google.protobuf
+	// package, such as google/protobuf/timestamp.proto, which protobuf
+	// implementations typically have built in.
+	ExcludeWellKnownTypes bool
+}
+
+// FileDescriptorSet returns the files selected by opts as a
+// FileDescriptorSet, with every file following its dependencies. Files
+// are otherwise in order of their paths.
+func (f *Files) FileDescriptorSet(opts FileDescriptorSetOptions) (*descriptorpb.FileDescriptorSet, error) {
+	var roots []protoreflect.FileDescriptor
+	for _, path := range opts.Files {
+		fd, err := f.FindFileByPath(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		roots = append(roots, fd)
+	}
+	for _, name := range opts.Symbols {
+		desc, err := f.FindDescriptorByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		roots = append(roots, desc.ParentFile())
+	}
+	if len(opts.Files) == 0 && len(opts.Symbols) == 0 {
+		f.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+			roots = append(roots, fd)
+			return true
+		})
+		sort.Slice(roots, func(i, j int) bool { return roots[i].Path() < roots[j].Path() })
+	}
+
+	fds := &descriptorpb.FileDescriptorSet{}
+	seen := map[string]bool{}
+	var add func(fd protoreflect.FileDescriptor)
+	add = func(fd protoreflect.FileDescriptor) {
+		if seen[fd.Path()] || fd.IsPlaceholder() {
+			return
+		}
+		seen[fd.Path()] = true
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			add(imports.Get(i).FileDescriptor)
+		}
+		if opts.ExcludeWellKnownTypes && isWellKnownFile(fd) {
+			return
+		}
+		fdp := protodesc.ToFileDescriptorProto(fd)
+		if !opts.SourceInfo {
+			fdp.SourceCodeInfo = nil
+		}
+		fds.File = append(fds.File, fdp)
+	}
+	for _, fd := range roots {
+		add(fd)
+	}
+	return fds, nil
+}
+
+func isWellKnownFile(fd protoreflect.FileDescriptor) bool {
+	return fd.Package() == "google.protobuf" && strings.HasPrefix(fd.Path(), "google/protobuf/")
+}
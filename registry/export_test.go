@@ -0,0 +1,93 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func fileNames(fds *descriptorpb.FileDescriptorSet) []string {
+	var names []string
+	for _, fdp := range fds.File {
+		names = append(names, fdp.GetName())
+	}
+	return names
+}
+
+func TestFileDescriptorSet(t *testing.T) {
+	f := newFiles(t)
+	tests := map[string]struct {
+		opts  FileDescriptorSetOptions
+		files []string
+	}{
+		"all files": {FileDescriptorSetOptions{}, []string{
+			"google/api/http.proto",
+			"google/protobuf/descriptor.proto",
+			"google/api/annotations.proto",
+			"google/protobuf/empty.proto",
+			"regtest.proto",
+		}},
+		"file": {FileDescriptorSetOptions{Files: []string{"google/api/annotations.proto"}}, []string{
+			"google/api/http.proto",
+			"google/protobuf/descriptor.proto",
+			"google/api/annotations.proto",
+		}},
+		"symbols": {FileDescriptorSetOptions{Symbols: []protoreflect.FullName{"google.api.HttpRule", "google.protobuf.Empty"}}, []string{
+			"google/api/http.proto",
+			"google/protobuf/empty.proto",
+		}},
+		"exclude well-known types": {FileDescriptorSetOptions{Symbols: []protoreflect.FullName{"regtest.ExtensionMessage"}, ExcludeWellKnownTypes: true}, []string{
+			"google/api/http.proto",
+			"google/api/annotations.proto",
+			"regtest.proto",
+		}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			fds, err := f.FileDescriptorSet(tc.opts)
+			require.NoError(t, err)
+			require.Equal(t, tc.files, fileNames(fds))
+		})
+	}
+}
+
+func TestFileDescriptorSetRoundTrip(t *testing.T) {
+	fds, err := newFiles(t).FileDescriptorSet(FileDescriptorSetOptions{})
+	require.NoError(t, err)
+	f, err := NewFiles(fds)
+	require.NoError(t, err)
+	_, err = f.FindExtensionByName("regtest.ExtensionMessage.NestedExtension.ef3")
+	require.NoError(t, err)
+}
+
+func TestFileDescriptorSetSourceInfo(t *testing.T) {
+	fds := newFDS(t)
+	for _, fdp := range fds.File {
+		fdp.SourceCodeInfo = &descriptorpb.SourceCodeInfo{Location: []*descriptorpb.SourceCodeInfo_Location{{
+			Path: []int32{4, 0}, Span: []int32{1, 0, 10}, LeadingComments: proto.String(" A message\n"),
+		}}}
+	}
+	f, err := NewFiles(fds)
+	require.NoError(t, err)
+
+	stripped, err := f.FileDescriptorSet(FileDescriptorSetOptions{Files: []string{"regtest.proto"}})
+	require.NoError(t, err)
+	kept, err := f.FileDescriptorSet(FileDescriptorSetOptions{Files: []string{"regtest.proto"}, SourceInfo: true})
+	require.NoError(t, err)
+	for i := range stripped.File {
+		require.Nil(t, stripped.File[i].SourceCodeInfo)
+		require.NotNil(t, kept.File[i].SourceCodeInfo)
+	}
+}
+
+func TestFileDescriptorSetNotFound(t *testing.T) {
+	f := newFiles(t)
+	_, err := f.FileDescriptorSet(FileDescriptorSetOptions{Files: []string{"unknown.proto"}})
+	require.ErrorIs(t, err, protoregistry.NotFound)
+	_, err = f.FileDescriptorSet(FileDescriptorSetOptions{Symbols: []protoreflect.FullName{"regtest.Foo"}})
+	require.ErrorIs(t, err, protoregistry.NotFound)
+}
@@ -5,7 +5,9 @@
 package registry
 
 import (
+	"errors"
 	"strings"
+	"sync"
 
 	"google.golang.org/protobuf/reflect/protodesc"
 	"google.golang.org/protobuf/reflect/protoreflect"
@@ -14,8 +16,47 @@ import (
 	"google.golang.org/protobuf/types/dynamicpb"
 )
 
+// Files is a protoregistry.Files that resolves message and extension types
+// and optionally fetches missing files on lookup. The methods of Files may
+// be called concurrently with each other, including RegisterFile. The
+// methods of the embedded protoregistry.Files that Files does not wrap must
+// not be called concurrently with RegisterFile.
 type Files struct {
 	protoregistry.Files
+
+	// extensions indexes the extensions of all registered files. It is
+	// built by NewFiles and kept up to date by RegisterFile. A Files
+	// created as a struct literal builds it on the first extension lookup.
+	extensions *extensionIndex
+	mu         sync.RWMutex // guards Files and extensions
+
+	// fetcher, if set, fetches the files missing from the registry on
+	// lookup. Fetches are serialized by fetchMu, which also guards the set
+	// of messages whose extensions have been fetched and the cache of
+	// lookups that fetching did not find.
+	fetcher           fileFetcher
+	fetchMu           sync.Mutex
+	fetchedExtensions map[protoreflect.FullName]bool
+	fetchMisses       map[interface{}]bool
+
+	// messageTypes caches the dynamic message type of each message
+	// descriptor so that lookups return the same type every time.
+	messageTypes sync.Map // protoreflect.MessageDescriptor -> protoreflect.MessageType
+}
+
+// extensionIndex holds the dynamic extension types of a Files, indexed by
+// full name, by extended message and field number, and by extended
+// message. Extension types are created once when they are indexed so that
+// lookups return the same type every time.
+type extensionIndex struct {
+	byName    map[protoreflect.FullName]protoreflect.ExtensionType
+	byNumber  map[extensionKey]protoreflect.ExtensionType
+	byMessage map[protoreflect.FullName][]protoreflect.ExtensionType
+}
+
+type extensionKey struct {
+	message protoreflect.FullName
+	field   protoreflect.FieldNumber
 }
 
 func NewFiles(fds *descriptorpb.FileDescriptorSet) (*Files, error) {
@@ -23,10 +64,32 @@ func NewFiles(fds *descriptorpb.FileDescriptorSet) (*Files, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Files{Files: *f}, nil
+	files := &Files{Files: *f, extensions: newExtensionIndex()}
+	// Index in the order of the file descriptor set so that extensions of
+	// the same message are returned in a stable order.
+	for _, fdp := range fds.File {
+		fd, err := f.FindFileByPath(fdp.GetName())
+		if err != nil {
+			return nil, err
+		}
+		files.extensions.add(fd)
+	}
+	return files, nil
 }
 
-type extMatchFn func(protoreflect.ExtensionDescriptor) bool
+// RegisterFile registers the file with the embedded protoregistry.Files
+// and adds its extensions to the extension index.
+func (f *Files) RegisterFile(fd protoreflect.FileDescriptor) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.Files.RegisterFile(fd); err != nil {
+		return err
+	}
+	if f.extensions != nil {
+		f.extensions.add(fd)
+	}
+	return nil
+}
 
 // extensionContainer is implemented by FileDescriptor and MessageDescriptor.
 // They are both "namespaces" that contain extensions and have "sub-namespaces".
@@ -35,63 +98,199 @@ type extensionContainer interface {
 	Extensions() protoreflect.ExtensionDescriptors
 }
 
+func newExtensionIndex() *extensionIndex {
+	return &extensionIndex{
+		byName:    map[protoreflect.FullName]protoreflect.ExtensionType{},
+		byNumber:  map[extensionKey]protoreflect.ExtensionType{},
+		byMessage: map[protoreflect.FullName][]protoreflect.ExtensionType{},
+	}
+}
+
+// add indexes the extensions of ec and its nested messages. If several
+// extensions extend a message with the same field number, the first one
+// added is found by number.
+func (x *extensionIndex) add(ec extensionContainer) {
+	eds := ec.Extensions()
+	for i := 0; i < eds.Len(); i++ {
+		ed := eds.Get(i)
+		if _, ok := x.byName[ed.FullName()]; ok {
+			continue
+		}
+		et := dynamicpb.NewExtensionType(ed)
+		message := ed.ContainingMessage().FullName()
+		key := extensionKey{message: message, field: ed.Number()}
+		x.byName[ed.FullName()] = et
+		if _, ok := x.byNumber[key]; !ok {
+			x.byNumber[key] = et
+		}
+		x.byMessage[message] = append(x.byMessage[message], et)
+	}
+
+	mds := ec.Messages()
+	for i := 0; i < mds.Len(); i++ {
+		x.add(mds.Get(i))
+	}
+}
+
+// withExtensionIndex calls fn with the extension index of f, building it
+// from the registered files if f was not created by NewFiles. fn is called
+// with f.mu held for reading.
+func (f *Files) withExtensionIndex(fn func(x *extensionIndex)) {
+	f.mu.RLock()
+	if f.extensions == nil {
+		f.mu.RUnlock()
+		f.mu.Lock()
+		if f.extensions == nil {
+			f.extensions = newExtensionIndex()
+			f.Files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+				f.extensions.add(fd)
+				return true
+			})
+		}
+		f.mu.Unlock()
+		f.mu.RLock()
+	}
+	defer f.mu.RUnlock()
+	fn(f.extensions)
+}
+
+func (f *Files) findExtensionByName(field protoreflect.FullName) (et protoreflect.ExtensionType, ok bool) {
+	f.withExtensionIndex(func(x *extensionIndex) { et, ok = x.byName[field] })
+	return et, ok
+}
+
+func (f *Files) findExtensionByNumber(message protoreflect.FullName, field protoreflect.FieldNumber) (et protoreflect.ExtensionType, ok bool) {
+	f.withExtensionIndex(func(x *extensionIndex) { et, ok = x.byNumber[extensionKey{message: message, field: field}] })
+	return et, ok
+}
+
 func (f *Files) FindExtensionByName(field protoreflect.FullName) (protoreflect.ExtensionType, error) {
-	desc, err := f.FindDescriptorByName(field)
-	if err != nil {
-		return nil, err
+	et, ok := f.findExtensionByName(field)
+	if !ok && f.fetcher != nil {
+		get := func() ([][]byte, error) { return f.fetcher.fileContainingSymbol(field) }
+		found := func() bool { et, ok = f.findExtensionByName(field); return ok }
+		if err := f.fetch(field, get, found); err != nil {
+			return nil, err
+		}
 	}
-	ed, ok := desc.(protoreflect.ExtensionDescriptor)
 	if !ok {
 		return nil, protoregistry.NotFound
 	}
-	return dynamicpb.NewExtensionType(ed), nil
+	return et, nil
 }
 
 func (f *Files) FindExtensionByNumber(message protoreflect.FullName, field protoreflect.FieldNumber) (protoreflect.ExtensionType, error) {
-	ets := f.walkExtensions(false, func(ed protoreflect.ExtensionDescriptor) bool {
-		return ed.ContainingMessage().FullName() == message && ed.Number() == field
-	})
-	if len(ets) == 0 {
+	et, ok := f.findExtensionByNumber(message, field)
+	if !ok && f.fetcher != nil {
+		get := func() ([][]byte, error) { return f.fetcher.fileContainingExtension(message, field) }
+		found := func() bool { et, ok = f.findExtensionByNumber(message, field); return ok }
+		if err := f.fetch(extensionKey{message, field}, get, found); err != nil {
+			return nil, err
+		}
+	}
+	if !ok {
 		return nil, protoregistry.NotFound
 	}
-	return ets[0], nil
+	return et, nil
 }
 
+// GetExtensionsOfMessage returns the extension types of the given message.
+// The returned slice is shared and its elements must not be modified.
 func (f *Files) GetExtensionsOfMessage(message protoreflect.FullName) []protoreflect.ExtensionType {
-	return f.walkExtensions(true, func(ed protoreflect.ExtensionDescriptor) bool {
-		return ed.ContainingMessage().FullName() == message
-	})
+	if f.fetcher != nil {
+		f.fetchExtensionsOf(message)
+	}
+	var ets []protoreflect.ExtensionType
+	f.withExtensionIndex(func(x *extensionIndex) { ets = x.byMessage[message] })
+	return ets[:len(ets):len(ets)]
+}
+
+// FindFileByPath looks up a file by the path, fetching it if f has a
+// fetcher.
+func (f *Files) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	fd, err := f.findFileByPath(path)
+	if errors.Is(err, protoregistry.NotFound) && f.fetcher != nil {
+		get := func() ([][]byte, error) { return f.fetcher.fileByPath(path) }
+		found := func() bool { fd, err = f.findFileByPath(path); return err == nil }
+		if err := f.fetch(path, get, found); err != nil {
+			return nil, err
+		}
+	}
+	return fd, err
 }
 
-func (f *Files) walkExtensions(getAll bool, pred extMatchFn) []protoreflect.ExtensionType {
-	var result []protoreflect.ExtensionType
+func (f *Files) findFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.Files.FindFileByPath(path)
+}
 
-	f.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
-		result = append(result, getExtensions(fd, getAll, pred)...)
-		// continue if we are getting all extensions or have none so far
-		return getAll || len(result) == 0
+// NumFiles reports the number of registered files.
+func (f *Files) NumFiles() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.Files.NumFiles()
+}
+
+// RangeFiles iterates over the files registered when it is called. Files
+// may be registered from fn, but are not visited.
+func (f *Files) RangeFiles(fn func(protoreflect.FileDescriptor) bool) {
+	f.mu.RLock()
+	var files []protoreflect.FileDescriptor
+	f.Files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		files = append(files, fd)
+		return true
 	})
-	return result
+	f.mu.RUnlock()
+	for _, fd := range files {
+		if !fn(fd) {
+			return
+		}
+	}
 }
 
-func getExtensions(ec extensionContainer, getAll bool, pred extMatchFn) []protoreflect.ExtensionType {
-	var result []protoreflect.ExtensionType
+// NumFilesByPackage reports the number of registered files in the package.
+func (f *Files) NumFilesByPackage(name protoreflect.FullName) int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.Files.NumFilesByPackage(name)
+}
 
-	eds := ec.Extensions()
-	for i := 0; i < eds.Len() && (getAll || len(result) == 0); i++ {
-		ed := eds.Get(i)
-		if pred(ed) {
-			result = append(result, dynamicpb.NewExtensionType(ed))
+// RangeFilesByPackage iterates over the files in the package registered
+// when it is called, like RangeFiles.
+func (f *Files) RangeFilesByPackage(name protoreflect.FullName, fn func(protoreflect.FileDescriptor) bool) {
+	f.mu.RLock()
+	var files []protoreflect.FileDescriptor
+	f.Files.RangeFilesByPackage(name, func(fd protoreflect.FileDescriptor) bool {
+		files = append(files, fd)
+		return true
+	})
+	f.mu.RUnlock()
+	for _, fd := range files {
+		if !fn(fd) {
+			return
 		}
 	}
+}
 
-	mds := ec.Messages()
-	for i := 0; i < mds.Len() && (getAll || len(result) == 0); i++ {
-		md := mds.Get(i)
-		result = append(result, getExtensions(md, getAll, pred)...)
+// FindDescriptorByName looks up a descriptor by the full name, fetching
+// the file containing it if f has a fetcher.
+func (f *Files) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	desc, err := f.findDescriptorByName(name)
+	if errors.Is(err, protoregistry.NotFound) && f.fetcher != nil {
+		get := func() ([][]byte, error) { return f.fetcher.fileContainingSymbol(name) }
+		found := func() bool { desc, err = f.findDescriptorByName(name); return err == nil }
+		if err := f.fetch(name, get, found); err != nil {
+			return nil, err
+		}
 	}
+	return desc, err
+}
 
-	return result
+func (f *Files) findDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.Files.FindDescriptorByName(name)
 }
 
 func (f *Files) FindMessageByName(name protoreflect.FullName) (protoreflect.MessageType, error) {
@@ -103,7 +302,16 @@ func (f *Files) FindMessageByName(name protoreflect.FullName) (protoreflect.Mess
 	if !ok {
 		return nil, protoregistry.NotFound
 	}
-	return dynamicpb.NewMessageType(md), nil
+	return f.messageType(md), nil
+}
+
+// messageType returns the cached dynamic message type of md.
+func (f *Files) messageType(md protoreflect.MessageDescriptor) protoreflect.MessageType {
+	if mt, ok := f.messageTypes.Load(md); ok {
+		return mt.(protoreflect.MessageType)
+	}
+	mt, _ := f.messageTypes.LoadOrStore(md, dynamicpb.NewMessageType(md))
+	return mt.(protoreflect.MessageType)
 }
 
 func (f *Files) FindMessageByURL(url string) (protoreflect.MessageType, error) {
@@ -6,6 +6,7 @@ import (
 
 	"github.com/stretchr/testify/require"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
 	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/reflect/protoregistry"
 	"google.golang.org/protobuf/types/descriptorpb"
@@ -103,6 +104,37 @@ func TestGetExtensionsOfMessage(t *testing.T) {
 	}
 }
 
+func TestExtensionIndexUnindexedFiles(t *testing.T) {
+	indexed := newFiles(t)
+
+	registered := &Files{}
+	indexed.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		require.NoError(t, registered.RegisterFile(fd))
+		return true
+	})
+	literal := &Files{Files: indexed.Files}
+
+	for name, f := range map[string]*Files{"registered": registered, "literal": literal} {
+		t.Run(name, func(t *testing.T) {
+			et, err := f.FindExtensionByNumber("google.protobuf.MethodOptions", 56789)
+			require.NoError(t, err)
+			require.Equal(t, protoreflect.FullName("regtest.base"), et.TypeDescriptor().FullName())
+			_, err = f.FindExtensionByName("regtest.ExtensionMessage.NestedExtension.ef3")
+			require.NoError(t, err)
+			require.Len(t, f.GetExtensionsOfMessage("regtest.BaseMessage"), 3)
+		})
+	}
+}
+
+func BenchmarkFindExtensionByNumber(b *testing.B) {
+	f := newFiles(b)
+	for i := 0; i < b.N; i++ {
+		if _, err := f.FindExtensionByNumber("google.protobuf.MethodOptions", 72295728); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func TestFindMessageByName(t *testing.T) {
 	tests := map[string]struct {
 		name string
@@ -129,6 +161,70 @@ func TestFindMessageByName(t *testing.T) {
 	}
 }
 
+func TestMessageTypesCached(t *testing.T) {
+	f := newFiles(t)
+	mt1, err := f.FindMessageByName("regtest.BaseMessage")
+	require.NoError(t, err)
+	mt2, err := f.FindMessageByURL("type.googleapis.com/regtest.BaseMessage")
+	require.NoError(t, err)
+	require.True(t, mt1 == mt2)
+}
+
+func TestConcurrentLookups(t *testing.T) {
+	indexed := newFiles(t)
+	f := &Files{Files: indexed.Files}
+	type result struct {
+		mt  protoreflect.MessageType
+		err error
+	}
+	results := make(chan result, 10)
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			mt, err := f.FindMessageByName("regtest.ExtensionMessage")
+			if err == nil {
+				_, err = f.FindExtensionByName("regtest.ef1")
+			}
+			results <- result{mt, err}
+		}()
+	}
+	var mt protoreflect.MessageType
+	for i := 0; i < cap(results); i++ {
+		r := <-results
+		require.NoError(t, r.err)
+		if mt == nil {
+			mt = r.mt
+		}
+		require.True(t, mt == r.mt)
+	}
+}
+
+func TestConcurrentRegisterFile(t *testing.T) {
+	f := newFiles(t)
+	n := f.NumFiles()
+	fds := []*descriptorpb.FileDescriptorProto{
+		{Name: proto.String("a.proto"), Package: proto.String("concurrent")},
+		{Name: proto.String("b.proto"), Package: proto.String("concurrent")},
+	}
+	errs := make(chan error, len(fds))
+	for _, fdp := range fds {
+		fdp := fdp
+		go func() {
+			fd, err := protodesc.NewFile(fdp, f)
+			if err == nil {
+				err = f.RegisterFile(fd)
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		f.RangeFiles(func(protoreflect.FileDescriptor) bool { return true })
+		f.RangeFilesByPackage("concurrent", func(protoreflect.FileDescriptor) bool { return true })
+		require.NoError(t, <-errs)
+	}
+	require.Equal(t, n+2, f.NumFiles())
+	require.Equal(t, 2, f.NumFilesByPackage("concurrent"))
+}
+
 func TestFindMessageByURL(t *testing.T) {
 	tests := map[string]struct {
 		url string
@@ -155,7 +251,7 @@ func TestFindMessageByURL(t *testing.T) {
 	}
 }
 
-func newFiles(t *testing.T) *Files {
+func newFiles(t testing.TB) *Files {
 	t.Helper()
 	b, err := os.ReadFile("testdata/regtest.pb")
 	require.NoError(t, err)
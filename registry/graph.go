@@ -0,0 +1,443 @@
+package registry
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Graph is the dependency graph of the files and types of a Files. Files
+// depend on the files they import. Messages depend on the types of their
+// fields and map values, and on the types of the extensions extending
+// them. Services depend on the input and output types of their methods.
+// Messages, enums and services also depend on the types of the messages
+// packed in google.protobuf.Any values of their options and the options
+// of their fields, enum values and methods.
+type Graph struct {
+	files digraph
+	types digraph
+	kinds map[protoreflect.FullName]string // "message", "enum" or "service"
+
+	err error // first error decoding options while building the graph
+}
+
+// digraph is a directed graph with string nodes, stored as the sorted
+// successors and predecessors of every node.
+type digraph struct {
+	out map[string][]string
+	in  map[string][]string
+}
+
+func newDigraph() digraph {
+	return digraph{out: map[string][]string{}, in: map[string][]string{}}
+}
+
+func (g digraph) addNode(node string) {
+	if _, ok := g.out[node]; !ok {
+		g.out[node] = nil
+		g.in[node] = nil
+	}
+}
+
+func (g digraph) addEdge(from, to string) {
+	g.addNode(from)
+	g.addNode(to)
+	g.out[from] = append(g.out[from], to)
+	g.in[to] = append(g.in[to], from)
+}
+
+// sort sorts and deduplicates the successors and predecessors.
+func (g digraph) sort() {
+	for _, edges := range []map[string][]string{g.out, g.in} {
+		for node, nodes := range edges {
+			edges[node] = sortedUnique(nodes)
+		}
+	}
+}
+
+func sortedUnique(nodes []string) []string {
+	sort.Strings(nodes)
+	result := nodes[:0]
+	for i, node := range nodes {
+		if i == 0 || node != nodes[i-1] {
+			result = append(result, node)
+		}
+	}
+	return result
+}
+
+// closure returns the sorted nodes reachable from node over the given
+// edges, not including node unless it is on a cycle.
+func (g digraph) closure(edges map[string][]string, node string) []string {
+	seen := map[string]bool{}
+	stack := append([]string(nil), edges[node]...)
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[n] {
+			continue
+		}
+		seen[n] = true
+		stack = append(stack, edges[n]...)
+	}
+	result := make([]string, 0, len(seen))
+	for n := range seen {
+		result = append(result, n)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// cycles returns the strongly connected components of the graph that
+// contain a cycle, each sorted, in order of their first node.
+func (g digraph) cycles() [][]string {
+	nodes := make([]string, 0, len(g.out))
+	for node := range g.out {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	// Tarjan's strongly connected components algorithm
+	index := map[string]int{}
+	lowlink := map[string]int{}
+	onStack := map[string]bool{}
+	var stack []string
+	var result [][]string
+	var connect func(node string)
+	connect = func(node string) {
+		index[node] = len(index)
+		lowlink[node] = index[node]
+		stack = append(stack, node)
+		onStack[node] = true
+		for _, next := range g.out[node] {
+			if _, ok := index[next]; !ok {
+				connect(next)
+				if lowlink[next] < lowlink[node] {
+					lowlink[node] = lowlink[next]
+				}
+			} else if onStack[next] && index[next] < lowlink[node] {
+				lowlink[node] = index[next]
+			}
+		}
+		if lowlink[node] != index[node] {
+			return
+		}
+		var component []string
+		for {
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[n] = false
+			component = append(component, n)
+			if n == node {
+				break
+			}
+		}
+		if len(component) > 1 || hasEdge(g.out[node], node) {
+			sort.Strings(component)
+			result = append(result, component)
+		}
+	}
+	for _, node := range nodes {
+		if _, ok := index[node]; !ok {
+			connect(node)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i][0] < result[j][0] })
+	return result
+}
+
+func hasEdge(nodes []string, node string) bool {
+	i := sort.SearchStrings(nodes, node)
+	return i < len(nodes) && nodes[i] == node
+}
+
+// writeDOT writes the subgraph of the given nodes, or the whole graph if
+// nodes is nil, in the DOT language. attrs returns the attributes of a
+// node.
+func (g digraph) writeDOT(w io.Writer, name string, nodes []string, attrs func(string) string) error {
+	if nodes == nil {
+		for node := range g.out {
+			nodes = append(nodes, node)
+		}
+	}
+	nodes = sortedUnique(append([]string(nil), nodes...))
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "digraph %q {\n", name)
+	for _, node := range nodes {
+		fmt.Fprintf(b, "  %q%s;\n", node, attrs(node))
+	}
+	for _, from := range nodes {
+		for _, to := range g.out[from] {
+			if hasEdge(nodes, to) {
+				fmt.Fprintf(b, "  %q -> %q;\n", from, to)
+			}
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// NewGraph returns the dependency graph of the files of f. If the options
+// of a type cannot be decoded, the graph is returned without the
+// references of those options, together with an error for the first of
+// them.
+func NewGraph(f *Files) (*Graph, error) {
+	g := &Graph{
+		files: newDigraph(),
+		types: newDigraph(),
+		kinds: map[protoreflect.FullName]string{},
+	}
+	var files []protoreflect.FileDescriptor
+	f.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		files = append(files, fd)
+		return true
+	})
+
+	for _, fd := range files {
+		g.files.addNode(fd.Path())
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			g.files.addEdge(fd.Path(), imports.Get(i).Path())
+		}
+		g.addTypes(f, fd)
+		sds := fd.Services()
+		for i := 0; i < sds.Len(); i++ {
+			sd := sds.Get(i)
+			g.addType(sd.FullName(), "service")
+			g.addOptionRefs(f, sd.FullName(), sd.Options())
+			mds := sd.Methods()
+			for j := 0; j < mds.Len(); j++ {
+				md := mds.Get(j)
+				g.addRef(sd.FullName(), md.Input().FullName())
+				g.addRef(sd.FullName(), md.Output().FullName())
+				g.addOptionRefs(f, sd.FullName(), md.Options())
+			}
+		}
+	}
+	g.files.sort()
+	g.types.sort()
+	return g, g.err
+}
+
+// addTypes adds the messages, enums and extensions of tc and its nested
+// messages. Map entry messages are part of the message of the map field.
+func (g *Graph) addTypes(f *Files, tc typesContainer) {
+	mds := tc.Messages()
+	for i := 0; i < mds.Len(); i++ {
+		md := mds.Get(i)
+		if md.IsMapEntry() {
+			continue
+		}
+		g.addType(md.FullName(), "message")
+		g.addOptionRefs(f, md.FullName(), md.Options())
+		fields := md.Fields()
+		for j := 0; j < fields.Len(); j++ {
+			fd := fields.Get(j)
+			if fd.IsMap() {
+				fd = fd.MapValue()
+			}
+			g.addFieldRef(md.FullName(), fd)
+			g.addOptionRefs(f, md.FullName(), fields.Get(j).Options())
+		}
+		g.addTypes(f, md)
+	}
+
+	eds := tc.Enums()
+	for i := 0; i < eds.Len(); i++ {
+		ed := eds.Get(i)
+		g.addType(ed.FullName(), "enum")
+		g.addOptionRefs(f, ed.FullName(), ed.Options())
+		values := ed.Values()
+		for j := 0; j < values.Len(); j++ {
+			g.addOptionRefs(f, ed.FullName(), values.Get(j).Options())
+		}
+	}
+
+	xds := tc.Extensions()
+	for i := 0; i < xds.Len(); i++ {
+		xd := xds.Get(i)
+		g.addFieldRef(xd.ContainingMessage().FullName(), xd)
+		g.addOptionRefs(f, xd.ContainingMessage().FullName(), xd.Options())
+	}
+}
+
+func (g *Graph) addType(name protoreflect.FullName, kind string) {
+	g.types.addNode(string(name))
+	g.kinds[name] = kind
+}
+
+func (g *Graph) addRef(from, to protoreflect.FullName) {
+	g.types.addEdge(string(from), string(to))
+}
+
+func (g *Graph) addFieldRef(from protoreflect.FullName, fd protoreflect.FieldDescriptor) {
+	switch {
+	case fd.Message() != nil:
+		g.addRef(from, fd.Message().FullName())
+	case fd.Enum() != nil:
+		g.addRef(from, fd.Enum().FullName())
+	}
+}
+
+// addOptionRefs adds references from a type to the messages packed in Any
+// values of options. The options are decoded again with f as resolver so
+// that custom options defined in f are found.
+func (g *Graph) addOptionRefs(f *Files, from protoreflect.FullName, opts proto.Message) {
+	if opts == nil || !opts.ProtoReflect().IsValid() {
+		return
+	}
+	b, err := proto.Marshal(opts)
+	if err != nil {
+		g.optionsError(from, err)
+		return
+	}
+	m := dynamicpb.NewMessage(opts.ProtoReflect().Descriptor())
+	if err := (proto.UnmarshalOptions{Resolver: f}).Unmarshal(b, m); err != nil {
+		g.optionsError(from, err)
+		return
+	}
+	for _, url := range anyTypeURLs(m) {
+		name := url
+		if i := strings.LastIndexByte(url, '/'); i >= 0 {
+			name = url[i+1:]
+		}
+		g.addRef(from, protoreflect.FullName(name))
+	}
+}
+
+// optionsError keeps the first error decoding the options of a type.
+func (g *Graph) optionsError(from protoreflect.FullName, err error) {
+	if g.err == nil {
+		g.err = fmt.Errorf("options of %s: %w", from, err)
+	}
+}
+
+// anyTypeURLs returns the type URLs of the Any messages in m.
+func anyTypeURLs(m protoreflect.Message) []string {
+	if m.Descriptor().FullName() == "google.protobuf.Any" {
+		if url := m.Get(m.Descriptor().Fields().ByName("type_url")).String(); url != "" {
+			return []string{url}
+		}
+		return nil
+	}
+	var urls []string
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsList() && fd.Message() != nil:
+			for i := 0; i < v.List().Len(); i++ {
+				urls = append(urls, anyTypeURLs(v.List().Get(i).Message())...)
+			}
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			v.Map().Range(func(_ protoreflect.MapKey, v protoreflect.Value) bool {
+				urls = append(urls, anyTypeURLs(v.Message())...)
+				return true
+			})
+		case !fd.IsList() && !fd.IsMap() && fd.Message() != nil:
+			urls = append(urls, anyTypeURLs(v.Message())...)
+		}
+		return true
+	})
+	return urls
+}
+
+// Imports returns the files imported by the file of the given path.
+func (g *Graph) Imports(path string) []string {
+	return g.files.out[path]
+}
+
+// ImportedBy returns the files importing the file of the given path.
+func (g *Graph) ImportedBy(path string) []string {
+	return g.files.in[path]
+}
+
+// TransitiveImports returns the files imported by the file of the given
+// path directly or indirectly.
+func (g *Graph) TransitiveImports(path string) []string {
+	return g.files.closure(g.files.out, path)
+}
+
+// TransitiveImportedBy returns the files importing the file of the given
+// path directly or indirectly.
+func (g *Graph) TransitiveImportedBy(path string) []string {
+	return g.files.closure(g.files.in, path)
+}
+
+// References returns the types the type of the given name depends on.
+func (g *Graph) References(name protoreflect.FullName) []protoreflect.FullName {
+	return fullNames(g.types.out[string(name)])
+}
+
+// ReferencedBy returns the types depending on the type of the given name.
+func (g *Graph) ReferencedBy(name protoreflect.FullName) []protoreflect.FullName {
+	return fullNames(g.types.in[string(name)])
+}
+
+// TransitiveReferences returns the types the type of the given name
+// depends on directly or indirectly.
+func (g *Graph) TransitiveReferences(name protoreflect.FullName) []protoreflect.FullName {
+	return fullNames(g.types.closure(g.types.out, string(name)))
+}
+
+// TransitiveReferencedBy returns the types depending on the type of the
+// given name directly or indirectly: the types affected by a change of it.
+func (g *Graph) TransitiveReferencedBy(name protoreflect.FullName) []protoreflect.FullName {
+	return fullNames(g.types.closure(g.types.in, string(name)))
+}
+
+// FileCycles returns the sets of files importing each other.
+func (g *Graph) FileCycles() [][]string {
+	return g.files.cycles()
+}
+
+// TypeCycles returns the sets of types depending on each other, such as
+// recursive messages.
+func (g *Graph) TypeCycles() [][]protoreflect.FullName {
+	var cycles [][]protoreflect.FullName
+	for _, cycle := range g.types.cycles() {
+		cycles = append(cycles, fullNames(cycle))
+	}
+	return cycles
+}
+
+// WriteFilesDOT writes the file import graph in the DOT language of
+// Graphviz.
+func (g *Graph) WriteFilesDOT(w io.Writer) error {
+	return g.files.writeDOT(w, "files", nil, func(string) string { return "" })
+}
+
+// WriteTypesDOT writes the subgraph of the types with the given names, or
+// of all types if names is nil, in the DOT language of Graphviz. Messages
+// are drawn as boxes, enums as ellipses and services as hexagons.
+func (g *Graph) WriteTypesDOT(w io.Writer, names []protoreflect.FullName) error {
+	var nodes []string
+	for _, name := range names {
+		nodes = append(nodes, string(name))
+	}
+	if names != nil && nodes == nil {
+		nodes = []string{}
+	}
+	shapes := map[string]string{"message": "box", "enum": "ellipse", "service": "hexagon"}
+	return g.types.writeDOT(w, "types", nodes, func(node string) string {
+		if shape, ok := shapes[g.kinds[protoreflect.FullName(node)]]; ok {
+			return fmt.Sprintf(" [shape=%s]", shape)
+		}
+		return ""
+	})
+}
+
+func fullNames(nodes []string) []protoreflect.FullName {
+	if len(nodes) == 0 {
+		return nil
+	}
+	names := make([]protoreflect.FullName, len(nodes))
+	for i, node := range nodes {
+		names[i] = protoreflect.FullName(node)
+	}
+	return names
+}
@@ -0,0 +1,139 @@
+package registry
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func newGraph(t *testing.T) *Graph {
+	t.Helper()
+	b, err := os.ReadFile("testdata/graphtest.pb")
+	require.NoError(t, err)
+	fds := &descriptorpb.FileDescriptorSet{}
+	require.NoError(t, proto.Unmarshal(b, fds))
+	f, err := NewFiles(fds)
+	require.NoError(t, err)
+	g, err := NewGraph(f)
+	require.NoError(t, err)
+	return g
+}
+
+func TestGraphFiles(t *testing.T) {
+	g := newGraph(t)
+	require.Equal(t, []string{"google/protobuf/any.proto", "google/protobuf/descriptor.proto"}, g.Imports("graphtest.proto"))
+	require.Equal(t, []string{"graphtest.proto"}, g.ImportedBy("google/protobuf/any.proto"))
+	require.Equal(t, g.Imports("graphtest.proto"), g.TransitiveImports("graphtest.proto"))
+	require.Equal(t, []string{"graphtest.proto"}, g.TransitiveImportedBy("google/protobuf/descriptor.proto"))
+	require.Empty(t, g.Imports("unknown.proto"))
+	require.Empty(t, g.FileCycles())
+}
+
+func TestGraphTypes(t *testing.T) {
+	g := newGraph(t)
+	tests := map[string]struct {
+		name         protoreflect.FullName
+		references   []protoreflect.FullName
+		referencedBy []protoreflect.FullName
+	}{
+		"recursive message with map": {"graphtest.Node", []protoreflect.FullName{"graphtest.Leaf", "graphtest.Node"}, []protoreflect.FullName{"graphtest.Node", "graphtest.Tree"}},
+		"enum":                       {"graphtest.Color", nil, []protoreflect.FullName{"graphtest.Leaf"}},
+		"extension type":             {"graphtest.Leaf", []protoreflect.FullName{"graphtest.Color"}, []protoreflect.FullName{"graphtest.Base", "graphtest.Node"}},
+		"Any hint in options":        {"graphtest.Hinted", nil, []protoreflect.FullName{"graphtest.Envelope"}},
+		"service":                    {"graphtest.Tree", []protoreflect.FullName{"graphtest.Envelope", "graphtest.Node"}, nil},
+		"custom option":              {"google.protobuf.FieldOptions", []protoreflect.FullName{"google.protobuf.Any", "google.protobuf.FieldOptions.CType", "google.protobuf.FieldOptions.JSType", "google.protobuf.UninterpretedOption"}, []protoreflect.FullName{"google.protobuf.FieldDescriptorProto"}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.references, g.References(tc.name))
+			require.Equal(t, tc.referencedBy, g.ReferencedBy(tc.name))
+		})
+	}
+
+	want := []protoreflect.FullName{"graphtest.Base", "graphtest.Leaf", "graphtest.Node", "graphtest.Tree"}
+	require.Equal(t, want, g.TransitiveReferencedBy("graphtest.Color"))
+	want = []protoreflect.FullName{"google.protobuf.Any", "graphtest.Color", "graphtest.Envelope", "graphtest.Hinted", "graphtest.Leaf", "graphtest.Node"}
+	require.Equal(t, want, g.TransitiveReferences("graphtest.Tree"))
+}
+
+func TestGraphTypeCycles(t *testing.T) {
+	g := newGraph(t)
+	cycles := g.TypeCycles()
+	require.Contains(t, cycles, []protoreflect.FullName{"graphtest.Node"})
+	for _, cycle := range cycles {
+		require.NotContains(t, cycle, protoreflect.FullName("graphtest.Leaf"))
+	}
+}
+
+func TestGraphOptionsError(t *testing.T) {
+	opts := &descriptorpb.MessageOptions{}
+	// a custom option holding a malformed Opt message
+	unknown := protowire.AppendTag(nil, 50000, protowire.BytesType)
+	unknown = protowire.AppendBytes(unknown, []byte{0xff})
+	opts.ProtoReflect().SetUnknown(unknown)
+	fds := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+		protodesc.ToFileDescriptorProto(descriptorpb.File_google_protobuf_descriptor_proto),
+		{
+			Name:       proto.String("opt.proto"),
+			Package:    proto.String("opt"),
+			Dependency: []string{"google/protobuf/descriptor.proto"},
+			MessageType: []*descriptorpb.DescriptorProto{
+				{Name: proto.String("Opt")},
+				{Name: proto.String("Bad"), Options: opts},
+			},
+			Extension: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("opt"),
+				Number:   proto.Int32(50000),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+				TypeName: proto.String(".opt.Opt"),
+				Extendee: proto.String(".google.protobuf.MessageOptions"),
+			}},
+		},
+	}}
+	f, err := NewFiles(fds)
+	require.NoError(t, err)
+	g, err := NewGraph(f)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "options of opt.Bad")
+	require.Contains(t, g.References("google.protobuf.MessageOptions"), protoreflect.FullName("opt.Opt"))
+}
+
+func TestDigraphCycles(t *testing.T) {
+	g := newDigraph()
+	g.addEdge("a", "b")
+	g.addEdge("b", "c")
+	g.addEdge("c", "a")
+	g.addEdge("c", "d")
+	g.addEdge("e", "e")
+	g.addEdge("d", "f")
+	g.sort()
+	require.Equal(t, [][]string{{"a", "b", "c"}, {"e"}}, g.cycles())
+}
+
+func TestGraphDOT(t *testing.T) {
+	g := newGraph(t)
+	b := &strings.Builder{}
+	require.NoError(t, g.WriteTypesDOT(b, []protoreflect.FullName{"graphtest.Leaf", "graphtest.Color", "graphtest.Base"}))
+	want := `digraph "types" {
+  "graphtest.Base" [shape=box];
+  "graphtest.Color" [shape=ellipse];
+  "graphtest.Leaf" [shape=box];
+  "graphtest.Base" -> "graphtest.Leaf";
+  "graphtest.Leaf" -> "graphtest.Color";
+}
+`
+	require.Equal(t, want, b.String())
+
+	b.Reset()
+	require.NoError(t, g.WriteFilesDOT(b))
+	require.Contains(t, b.String(), `  "graphtest.proto" -> "google/protobuf/any.proto";`)
+	require.Contains(t, b.String(), `  "google/protobuf/descriptor.proto";`)
+}
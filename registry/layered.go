@@ -0,0 +1,254 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// Layered is a resolver that stacks several resolvers, such as
+// protoregistry.Types, protoregistry.Files, Files or custom ones. A lookup
+// asks each layer in order and returns the result of the first layer that
+// finds the name, so that earlier layers take precedence over later ones.
+//
+// A layer is asked for the lookups of the resolver interfaces it
+// implements: protoregistry.MessageTypeResolver,
+// protoregistry.ExtensionTypeResolver and protodesc.Resolver. Layers that
+// do not implement protodesc.Resolver are asked for descriptors through
+// their message, enum and extension types.
+type Layered struct {
+	// OnShadow, if set, is called the first time a name is resolved by a
+	// layer while a later layer has a definition of the same name too.
+	// Looking for shadowed names takes a lookup in every later layer, so
+	// OnShadow is best used for diagnostics.
+	OnShadow func(Shadow)
+
+	layers   []interface{}
+	shadowed sync.Map // Shadow -> struct{}
+}
+
+// Shadow describes a name resolved by one layer of a Layered resolver
+// that is also defined in a later layer.
+type Shadow struct {
+	Name     protoreflect.FullName
+	Layer    int // index of the layer the name is resolved by
+	Shadowed int // index of the shadowed layer
+}
+
+func (s Shadow) String() string {
+	return fmt.Sprintf("%s: layer %d shadows layer %d", s.Name, s.Layer, s.Shadowed)
+}
+
+// enumTypeResolver is implemented by protoregistry.Types.
+type enumTypeResolver interface {
+	FindEnumByName(protoreflect.FullName) (protoreflect.EnumType, error)
+}
+
+// NewLayered returns a Layered resolver of the given layers, the first
+// layer taking the highest precedence. It fails if a layer implements
+// none of the resolver interfaces.
+func NewLayered(layers ...interface{}) (*Layered, error) {
+	for i, layer := range layers {
+		switch layer.(type) {
+		case protoregistry.MessageTypeResolver, protoregistry.ExtensionTypeResolver, protodesc.Resolver:
+		default:
+			return nil, fmt.Errorf("layer %d: %T is not a resolver", i, layer)
+		}
+	}
+	return &Layered{layers: layers}, nil
+}
+
+// find looks up a name in the layers with the given lookup function,
+// which returns the full name of what it finds, or false for layers that
+// do not support the lookup. The first result found is kept by lookup;
+// later calls are for reporting shadowed names. Errors of a layer, such
+// as protoregistry.Types finding a name of the wrong kind, do not stop the
+// search: find returns the first of them if no layer finds the name, and
+// protoregistry.NotFound if there are none.
+func (l *Layered) find(lookup func(layer interface{}) (protoreflect.FullName, bool, error)) error {
+	var firstErr error
+	for i, layer := range l.layers {
+		name, ok, err := lookup(layer)
+		if !ok {
+			continue
+		}
+		if err != nil {
+			if firstErr == nil && !errors.Is(err, protoregistry.NotFound) {
+				firstErr = err
+			}
+			continue
+		}
+		if l.OnShadow != nil {
+			l.reportShadows(i, name, lookup)
+		}
+		return nil
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	return protoregistry.NotFound
+}
+
+func (l *Layered) reportShadows(found int, name protoreflect.FullName, lookup func(layer interface{}) (protoreflect.FullName, bool, error)) {
+	for i := found + 1; i < len(l.layers); i++ {
+		if _, ok, err := lookup(l.layers[i]); !ok || err != nil {
+			continue
+		}
+		s := Shadow{Name: name, Layer: found, Shadowed: i}
+		if _, reported := l.shadowed.LoadOrStore(s, struct{}{}); !reported {
+			l.OnShadow(s)
+		}
+	}
+}
+
+func (l *Layered) FindMessageByName(name protoreflect.FullName) (protoreflect.MessageType, error) {
+	var mt protoreflect.MessageType
+	err := l.find(func(layer interface{}) (protoreflect.FullName, bool, error) {
+		r, ok := layer.(protoregistry.MessageTypeResolver)
+		if !ok {
+			return "", false, nil
+		}
+		found, err := r.FindMessageByName(name)
+		if err == nil && mt == nil {
+			mt = found
+		}
+		return name, true, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mt, nil
+}
+
+func (l *Layered) FindMessageByURL(url string) (protoreflect.MessageType, error) {
+	var mt protoreflect.MessageType
+	err := l.find(func(layer interface{}) (protoreflect.FullName, bool, error) {
+		r, ok := layer.(protoregistry.MessageTypeResolver)
+		if !ok {
+			return "", false, nil
+		}
+		found, err := r.FindMessageByURL(url)
+		if err != nil {
+			return "", true, err
+		}
+		if mt == nil {
+			mt = found
+		}
+		return found.Descriptor().FullName(), true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mt, nil
+}
+
+func (l *Layered) FindExtensionByName(field protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	var et protoreflect.ExtensionType
+	err := l.find(func(layer interface{}) (protoreflect.FullName, bool, error) {
+		r, ok := layer.(protoregistry.ExtensionTypeResolver)
+		if !ok {
+			return "", false, nil
+		}
+		found, err := r.FindExtensionByName(field)
+		if err == nil && et == nil {
+			et = found
+		}
+		return field, true, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return et, nil
+}
+
+func (l *Layered) FindExtensionByNumber(message protoreflect.FullName, field protoreflect.FieldNumber) (protoreflect.ExtensionType, error) {
+	var et protoreflect.ExtensionType
+	err := l.find(func(layer interface{}) (protoreflect.FullName, bool, error) {
+		r, ok := layer.(protoregistry.ExtensionTypeResolver)
+		if !ok {
+			return "", false, nil
+		}
+		found, err := r.FindExtensionByNumber(message, field)
+		if err != nil {
+			return "", true, err
+		}
+		if et == nil {
+			et = found
+		}
+		return found.TypeDescriptor().FullName(), true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return et, nil
+}
+
+// FindFileByPath returns the file of the first layer implementing
+// protodesc.Resolver that has a file with the given path.
+func (l *Layered) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	var fd protoreflect.FileDescriptor
+	err := l.find(func(layer interface{}) (protoreflect.FullName, bool, error) {
+		r, ok := layer.(protodesc.Resolver)
+		if !ok {
+			return "", false, nil
+		}
+		found, err := r.FindFileByPath(path)
+		if err != nil {
+			return "", true, err
+		}
+		if fd == nil {
+			fd = found
+		}
+		return protoreflect.FullName(path), true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fd, nil
+}
+
+func (l *Layered) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	var desc protoreflect.Descriptor
+	err := l.find(func(layer interface{}) (protoreflect.FullName, bool, error) {
+		found, err := findDescriptor(layer, name)
+		if err == nil && desc == nil {
+			desc = found
+		}
+		return name, true, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return desc, nil
+}
+
+// findDescriptor finds the descriptor of the given name in a layer. Layers
+// that are not a protodesc.Resolver are asked for a message, enum or
+// extension type of the name. protoregistry.Types fails with an error
+// other than protoregistry.NotFound if the name is of another kind, so
+// each kind is tried regardless of the errors of the others.
+func findDescriptor(layer interface{}, name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	if r, ok := layer.(protodesc.Resolver); ok {
+		return r.FindDescriptorByName(name)
+	}
+	if r, ok := layer.(protoregistry.MessageTypeResolver); ok {
+		if mt, err := r.FindMessageByName(name); err == nil {
+			return mt.Descriptor(), nil
+		}
+	}
+	if r, ok := layer.(enumTypeResolver); ok {
+		if et, err := r.FindEnumByName(name); err == nil {
+			return et.Descriptor(), nil
+		}
+	}
+	if r, ok := layer.(protoregistry.ExtensionTypeResolver); ok {
+		if et, err := r.FindExtensionByName(name); err == nil {
+			return et.TypeDescriptor(), nil
+		}
+	}
+	return nil, protoregistry.NotFound
+}
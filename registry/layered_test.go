@@ -0,0 +1,151 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// ensure Layered implements the resolver interfaces
+var (
+	_ protoregistry.MessageTypeResolver   = (*Layered)(nil)
+	_ protoregistry.ExtensionTypeResolver = (*Layered)(nil)
+	_ protodesc.Resolver                  = (*Layered)(nil)
+)
+
+func TestLayeredPrecedence(t *testing.T) {
+	files := newFiles(t)
+
+	l, err := NewLayered(protoregistry.GlobalTypes, files)
+	require.NoError(t, err)
+	mt, err := l.FindMessageByName("google.protobuf.FileDescriptorSet")
+	require.NoError(t, err)
+	require.IsType(t, &descriptorpb.FileDescriptorSet{}, mt.New().Interface())
+	mt, err = l.FindMessageByURL("type.googleapis.com/regtest.BaseMessage")
+	require.NoError(t, err)
+	require.IsType(t, &dynamicpb.Message{}, mt.New().Interface())
+
+	l, err = NewLayered(files, protoregistry.GlobalTypes)
+	require.NoError(t, err)
+	mt, err = l.FindMessageByName("google.protobuf.FileDescriptorSet")
+	require.NoError(t, err)
+	require.IsType(t, &dynamicpb.Message{}, mt.New().Interface())
+
+	_, err = l.FindMessageByName("regtest.Foo")
+	require.ErrorIs(t, err, protoregistry.NotFound)
+}
+
+func TestLayeredWrongKind(t *testing.T) {
+	newTypes := func(fdp *descriptorpb.FileDescriptorProto) *protoregistry.Types {
+		fd, err := protodesc.NewFile(fdp, &protoregistry.Files{})
+		require.NoError(t, err)
+		types := &protoregistry.Types{}
+		for _, typ := range appendDynamicTypes(nil, fd) {
+			require.NoError(t, registerType(types, typ))
+		}
+		return types
+	}
+	enum := newTypes(&descriptorpb.FileDescriptorProto{
+		Name:     proto.String("enum.proto"),
+		Package:  proto.String("x"),
+		EnumType: []*descriptorpb.EnumDescriptorProto{{Name: proto.String("Foo"), Value: []*descriptorpb.EnumValueDescriptorProto{{Name: proto.String("FOO"), Number: proto.Int32(0)}}}},
+	})
+	message := newTypes(&descriptorpb.FileDescriptorProto{
+		Name:        proto.String("message.proto"),
+		Package:     proto.String("x"),
+		MessageType: []*descriptorpb.DescriptorProto{{Name: proto.String("Foo")}},
+	})
+
+	l, err := NewLayered(enum, message)
+	require.NoError(t, err)
+	mt, err := l.FindMessageByName("x.Foo")
+	require.NoError(t, err)
+	require.Equal(t, protoreflect.FullName("x.Foo"), mt.Descriptor().FullName())
+	_, err = l.FindMessageByURL("type.googleapis.com/x.Foo")
+	require.NoError(t, err)
+
+	// without a layer finding it, the error of the wrong kind is returned
+	l, err = NewLayered(enum, &protoregistry.Types{})
+	require.NoError(t, err)
+	_, err = l.FindMessageByName("x.Foo")
+	require.Error(t, err)
+}
+
+func TestLayeredExtensions(t *testing.T) {
+	l, err := NewLayered(&protoregistry.Types{}, newFiles(t))
+	require.NoError(t, err)
+
+	et, err := l.FindExtensionByName("regtest.ExtensionMessage.ef2")
+	require.NoError(t, err)
+	require.Equal(t, protoreflect.FieldNumber(1001), et.TypeDescriptor().Number())
+	et, err = l.FindExtensionByNumber("google.protobuf.MethodOptions", 56789)
+	require.NoError(t, err)
+	require.Equal(t, protoreflect.FullName("regtest.base"), et.TypeDescriptor().FullName())
+
+	_, err = l.FindExtensionByName("unknown.extension")
+	require.ErrorIs(t, err, protoregistry.NotFound)
+	_, err = l.FindExtensionByNumber("regtest.BaseMessage", 999)
+	require.ErrorIs(t, err, protoregistry.NotFound)
+}
+
+func TestLayeredDescriptors(t *testing.T) {
+	l, err := NewLayered(protoregistry.GlobalTypes, newFiles(t))
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		name string
+		err  error
+	}{
+		"message from types": {"google.protobuf.FileDescriptorSet", nil},
+		"enum from types":    {"google.protobuf.FieldDescriptorProto.Type", nil},
+		"message from files": {"regtest.BaseMessage", nil},
+		"service from files": {"regtest.Dummy", nil},
+		"unknown descriptor": {"regtest.Foo", protoregistry.NotFound},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			desc, err := l.FindDescriptorByName(protoreflect.FullName(tc.name))
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, protoreflect.FullName(tc.name), desc.FullName())
+			}
+		})
+	}
+
+	fd, err := l.FindFileByPath("regtest.proto")
+	require.NoError(t, err)
+	require.Equal(t, "regtest.proto", fd.Path())
+	_, err = l.FindFileByPath("unknown.proto")
+	require.ErrorIs(t, err, protoregistry.NotFound)
+}
+
+func TestLayeredOnShadow(t *testing.T) {
+	l, err := NewLayered(protoregistry.GlobalTypes, &protoregistry.Types{}, newFiles(t))
+	require.NoError(t, err)
+	var shadows []Shadow
+	l.OnShadow = func(s Shadow) { shadows = append(shadows, s) }
+
+	for i := 0; i < 2; i++ {
+		_, err = l.FindMessageByName("google.protobuf.FileDescriptorSet")
+		require.NoError(t, err)
+	}
+	_, err = l.FindMessageByName("regtest.BaseMessage")
+	require.NoError(t, err)
+
+	want := []Shadow{{Name: "google.protobuf.FileDescriptorSet", Layer: 0, Shadowed: 2}}
+	require.Equal(t, want, shadows)
+	require.Equal(t, "google.protobuf.FileDescriptorSet: layer 0 shadows layer 2", shadows[0].String())
+}
+
+func TestNewLayeredNotResolver(t *testing.T) {
+	_, err := NewLayered(protoregistry.GlobalTypes, "types")
+	require.Error(t, err)
+}
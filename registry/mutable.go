@@ -0,0 +1,161 @@
+package registry
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Mutable is a registry of files that can be added and removed while it is
+// used for lookups from other goroutines. Changes are copy-on-write: every
+// change builds a new Files snapshot, reusing the descriptors of the files
+// it keeps, and swaps it in atomically. Lookups never wait for changes, and
+// every lookup sees a single snapshot.
+type Mutable struct {
+	mu    sync.Mutex // serializes changes and guards paths
+	paths []string   // registered files in registration order
+	files atomic.Value
+}
+
+// NewMutable returns a Mutable with the files of the given
+// FileDescriptorSet, which may be nil.
+func NewMutable(fds *descriptorpb.FileDescriptorSet) (*Mutable, error) {
+	m := &Mutable{}
+	m.files.Store(&Files{extensions: newExtensionIndex()})
+	if fds != nil {
+		if err := m.AddFiles(fds); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Snapshot returns the current files. The returned Files does not change;
+// use it for several lookups that need to be consistent with each other.
+func (m *Mutable) Snapshot() *Files {
+	return m.files.Load().(*Files)
+}
+
+// AddFiles adds the files of the given FileDescriptorSet. It fails without
+// adding any file if a file is already registered or cannot be built.
+func (m *Mutable) AddFiles(fds *descriptorpb.FileDescriptorSet) error {
+	return m.Update(nil, fds)
+}
+
+// RemoveFiles removes the files with the given paths. It fails without
+// removing any file if a file is not registered or is imported by a file
+// that is kept.
+func (m *Mutable) RemoveFiles(paths ...string) error {
+	return m.Update(paths, nil)
+}
+
+// Update removes the files with the given paths and adds the files of the
+// given FileDescriptorSet, which may be nil, in one change. Files can be
+// replaced by removing and adding them. If Update fails, the files are
+// left unchanged.
+func (m *Mutable) Update(remove []string, add *descriptorpb.FileDescriptorSet) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	prev := m.Snapshot()
+	removed := map[string]bool{}
+	for _, path := range remove {
+		if _, err := prev.findFileByPath(path); err != nil {
+			return fmt.Errorf("cannot remove %s: %w", path, err)
+		}
+		removed[path] = true
+	}
+
+	next := &Files{extensions: newExtensionIndex()}
+	var paths []string
+	for _, path := range m.paths {
+		if removed[path] {
+			continue
+		}
+		fd, err := prev.findFileByPath(path)
+		if err != nil {
+			return err
+		}
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			if imp := imports.Get(i).Path(); removed[imp] {
+				return fmt.Errorf("cannot remove %s: imported by %s", imp, path)
+			}
+		}
+		if err := next.RegisterFile(fd); err != nil {
+			return err
+		}
+		paths = append(paths, path)
+	}
+
+	added := map[string]*descriptorpb.FileDescriptorProto{}
+	for _, fdp := range add.GetFile() {
+		added[fdp.GetName()] = fdp
+	}
+	var addFile func(fdp *descriptorpb.FileDescriptorProto) error
+	addFile = func(fdp *descriptorpb.FileDescriptorProto) error {
+		if _, err := next.findFileByPath(fdp.GetName()); err == nil {
+			if added[fdp.GetName()] == nil {
+				return nil // dependency added already
+			}
+			return fmt.Errorf("cannot add %s: already registered", fdp.GetName())
+		}
+		delete(added, fdp.GetName())
+		for _, dep := range fdp.Dependency {
+			if depFDP, ok := added[dep]; ok {
+				if err := addFile(depFDP); err != nil {
+					return err
+				}
+			}
+		}
+		fd, err := protodesc.NewFile(fdp, &next.Files)
+		if err != nil {
+			return err
+		}
+		if err := next.RegisterFile(fd); err != nil {
+			return err
+		}
+		paths = append(paths, fdp.GetName())
+		return nil
+	}
+	for _, fdp := range add.GetFile() {
+		if err := addFile(fdp); err != nil {
+			return err
+		}
+	}
+
+	m.paths = paths
+	m.files.Store(next)
+	return nil
+}
+
+func (m *Mutable) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	return m.Snapshot().FindFileByPath(path)
+}
+
+func (m *Mutable) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	return m.Snapshot().FindDescriptorByName(name)
+}
+
+func (m *Mutable) FindMessageByName(name protoreflect.FullName) (protoreflect.MessageType, error) {
+	return m.Snapshot().FindMessageByName(name)
+}
+
+func (m *Mutable) FindMessageByURL(url string) (protoreflect.MessageType, error) {
+	return m.Snapshot().FindMessageByURL(url)
+}
+
+func (m *Mutable) FindExtensionByName(field protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	return m.Snapshot().FindExtensionByName(field)
+}
+
+func (m *Mutable) FindExtensionByNumber(message protoreflect.FullName, field protoreflect.FieldNumber) (protoreflect.ExtensionType, error) {
+	return m.Snapshot().FindExtensionByNumber(message, field)
+}
+
+func (m *Mutable) GetExtensionsOfMessage(message protoreflect.FullName) []protoreflect.ExtensionType {
+	return m.Snapshot().GetExtensionsOfMessage(message)
+}
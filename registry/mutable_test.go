@@ -0,0 +1,95 @@
+package registry
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// ensure Mutable implements the resolver interfaces
+var (
+	_ protoregistry.MessageTypeResolver   = (*Mutable)(nil)
+	_ protoregistry.ExtensionTypeResolver = (*Mutable)(nil)
+	_ protodesc.Resolver                  = (*Mutable)(nil)
+)
+
+func TestMutable(t *testing.T) {
+	m, err := NewMutable(newFDS(t))
+	require.NoError(t, err)
+	before := m.Snapshot()
+
+	other := otherFDS()
+	require.NoError(t, m.AddFiles(other))
+	et, err := m.FindExtensionByNumber("regtest.BaseMessage", 2000)
+	require.NoError(t, err)
+	require.Equal(t, protoreflect.FullName("other.other_ef"), et.TypeDescriptor().FullName())
+	require.Len(t, m.GetExtensionsOfMessage("regtest.BaseMessage"), 4)
+
+	// earlier snapshots do not change
+	_, err = before.FindMessageByName("other.OtherMessage")
+	require.ErrorIs(t, err, protoregistry.NotFound)
+	require.Len(t, before.GetExtensionsOfMessage("regtest.BaseMessage"), 3)
+
+	// failed changes leave the files unchanged
+	snapshot := m.Snapshot()
+	require.Error(t, m.AddFiles(other))
+	require.Error(t, m.RemoveFiles("regtest.proto"))
+	require.Error(t, m.RemoveFiles("unknown.proto"))
+	require.Same(t, snapshot, m.Snapshot())
+
+	require.NoError(t, m.RemoveFiles("other.proto"))
+	_, err = m.FindMessageByName("other.OtherMessage")
+	require.ErrorIs(t, err, protoregistry.NotFound)
+	require.NoError(t, m.RemoveFiles("regtest.proto"))
+	_, err = m.FindDescriptorByName("regtest.BaseMessage")
+	require.ErrorIs(t, err, protoregistry.NotFound)
+	fd, err := m.FindFileByPath("google/protobuf/descriptor.proto")
+	require.NoError(t, err)
+	require.Equal(t, "google/protobuf/descriptor.proto", fd.Path())
+}
+
+func TestMutableUpdateReplaces(t *testing.T) {
+	m, err := NewMutable(newFDS(t))
+	require.NoError(t, err)
+	require.NoError(t, m.AddFiles(otherFDS()))
+
+	other := otherFDS()
+	other.File[0].MessageType[0].Name = proto.String("RenamedMessage")
+	require.NoError(t, m.Update([]string{"other.proto"}, other))
+	_, err = m.FindMessageByName("other.RenamedMessage")
+	require.NoError(t, err)
+	_, err = m.FindMessageByName("other.OtherMessage")
+	require.ErrorIs(t, err, protoregistry.NotFound)
+}
+
+func TestMutableConcurrent(t *testing.T) {
+	m, err := NewMutable(newFDS(t))
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := m.FindMessageByName("regtest.BaseMessage")
+				require.NoError(t, err)
+				// other.proto and its extension come and go together
+				f := m.Snapshot()
+				_, errMessage := f.FindMessageByName("other.OtherMessage")
+				_, errExtension := f.FindExtensionByNumber("regtest.BaseMessage", 2000)
+				require.Equal(t, errMessage == nil, errExtension == nil)
+			}
+		}()
+	}
+	for j := 0; j < 50; j++ {
+		require.NoError(t, m.AddFiles(otherFDS()))
+		require.NoError(t, m.RemoveFiles("other.proto"))
+	}
+	wg.Wait()
+}
@@ -0,0 +1,273 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// fileFetcher fetches files missing from a Files. The methods fetching
+// files return the encoded FileDescriptorProto of the file asked for,
+// possibly followed by some of its dependencies.
+type fileFetcher interface {
+	fileByPath(path string) ([][]byte, error)
+	fileContainingSymbol(name protoreflect.FullName) ([][]byte, error)
+	fileContainingExtension(message protoreflect.FullName, field protoreflect.FieldNumber) ([][]byte, error)
+	extensionNumbers(message protoreflect.FullName) ([]protoreflect.FieldNumber, error)
+}
+
+// Methods of the v1 and v1alpha gRPC server reflection services. Their
+// messages are the same, so the v1alpha messages are used for both.
+const (
+	reflectionV1      = "/grpc.reflection.v1.ServerReflection/ServerReflectionInfo"
+	reflectionV1Alpha = "/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo"
+)
+
+var reflectionStreamDesc = &grpc.StreamDesc{
+	StreamName:    "ServerReflectionInfo",
+	ServerStreams: true,
+	ClientStreams: true,
+}
+
+// NewReflectionFiles returns a Files with the file descriptors of the gRPC
+// server connected to by cc, using the v1 server reflection service or the
+// v1alpha one if the server does not support v1. Files are fetched on
+// demand when a lookup does not find a name, together with their
+// dependencies. The extensions of a message are fetched when they are
+// listed with GetExtensionsOfMessage. Names, paths and extension numbers
+// the server does not know are not asked for again. ctx is used for all
+// requests to the reflection service.
+//
+// Fetches are serialized: a lookup that needs a fetch waits for the fetches
+// of concurrent lookups to finish, including their round trips to the
+// server, so that every file is fetched once. Lookups of registered names
+// do not wait.
+func NewReflectionFiles(ctx context.Context, cc grpc.ClientConnInterface) (*Files, error) {
+	c := &reflectionClient{ctx: ctx, cc: cc}
+	var err error
+	for _, c.method = range []string{reflectionV1, reflectionV1Alpha} {
+		req := &rpb.ServerReflectionRequest{
+			MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+		}
+		if _, err = c.request(req); status.Code(err) != codes.Unimplemented {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot use server reflection: %w", err)
+	}
+	return &Files{
+		extensions:        newExtensionIndex(),
+		fetcher:           c,
+		fetchedExtensions: map[protoreflect.FullName]bool{},
+		fetchMisses:       map[interface{}]bool{},
+	}, nil
+}
+
+// fetch registers the files returned by get and their dependencies,
+// fetching the dependencies that get did not return, unless found reports
+// that what is looked up is registered already. Lookups that are not
+// found are cached by key, a file path, a full name or an extensionKey, so
+// that they are not fetched again: protobuf unmarshaling looks up every
+// unknown extension field it decodes. fetchMu is held across the requests
+// to the server.
+func (f *Files) fetch(key interface{}, get func() ([][]byte, error), found func() bool) error {
+	f.fetchMu.Lock()
+	defer f.fetchMu.Unlock()
+	if found() {
+		return nil
+	}
+	if f.fetchMisses[key] {
+		return protoregistry.NotFound
+	}
+	err := f.fetchLocked(get)
+	if errors.Is(err, protoregistry.NotFound) || err == nil && !found() {
+		f.fetchMisses[key] = true
+	}
+	return err
+}
+
+func (f *Files) fetchLocked(get func() ([][]byte, error)) error {
+	fetched := map[string]*descriptorpb.FileDescriptorProto{}
+	paths, err := decodeFiles(get, fetched)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := f.registerFetched(path, fetched, map[string]bool{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registerFetched registers the file of the given path and its
+// dependencies, taking them from fetched or fetching them. importing holds
+// the files whose dependencies are being registered, to detect import
+// cycles.
+func (f *Files) registerFetched(path string, fetched map[string]*descriptorpb.FileDescriptorProto, importing map[string]bool) error {
+	if _, err := f.findFileByPath(path); err == nil {
+		return nil
+	}
+	if importing[path] {
+		return fmt.Errorf("%s: import cycle", path)
+	}
+	importing[path] = true
+	defer delete(importing, path)
+	fdp, ok := fetched[path]
+	if !ok {
+		if _, err := decodeFiles(func() ([][]byte, error) { return f.fetcher.fileByPath(path) }, fetched); err != nil {
+			return err
+		}
+		if fdp, ok = fetched[path]; !ok {
+			return fmt.Errorf("%s: %w", path, protoregistry.NotFound)
+		}
+	}
+	for _, dep := range fdp.Dependency {
+		if err := f.registerFetched(dep, fetched, importing); err != nil {
+			return err
+		}
+	}
+	f.mu.RLock()
+	fd, err := protodesc.NewFile(fdp, &f.Files)
+	f.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return f.RegisterFile(fd)
+}
+
+// decodeFiles adds the files returned by get to fetched, keyed by path,
+// and returns their paths in the order returned.
+func decodeFiles(get func() ([][]byte, error), fetched map[string]*descriptorpb.FileDescriptorProto) ([]string, error) {
+	bs, err := get()
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(bs))
+	for _, b := range bs {
+		fdp := &descriptorpb.FileDescriptorProto{}
+		if err := proto.Unmarshal(b, fdp); err != nil {
+			return nil, err
+		}
+		if _, ok := fetched[fdp.GetName()]; !ok {
+			fetched[fdp.GetName()] = fdp
+		}
+		paths = append(paths, fdp.GetName())
+	}
+	return paths, nil
+}
+
+// fetchExtensionsOf fetches the files of the extensions of a message that
+// are not registered yet, once per message. Errors are ignored, leaving
+// the extensions that could not be fetched out.
+func (f *Files) fetchExtensionsOf(message protoreflect.FullName) {
+	f.fetchMu.Lock()
+	defer f.fetchMu.Unlock()
+	if f.fetchedExtensions[message] {
+		return
+	}
+	numbers, err := f.fetcher.extensionNumbers(message)
+	if err != nil {
+		return
+	}
+	for _, field := range numbers {
+		if _, ok := f.findExtensionByNumber(message, field); ok {
+			continue
+		}
+		field := field
+		if err := f.fetchLocked(func() ([][]byte, error) { return f.fetcher.fileContainingExtension(message, field) }); err != nil {
+			return
+		}
+	}
+	f.fetchedExtensions[message] = true
+}
+
+// reflectionClient fetches files from the gRPC server reflection service,
+// with a new stream for every request.
+type reflectionClient struct {
+	ctx    context.Context
+	cc     grpc.ClientConnInterface
+	method string
+}
+
+func (c *reflectionClient) request(req *rpb.ServerReflectionRequest) (*rpb.ServerReflectionResponse, error) {
+	ctx, cancel := context.WithCancel(c.ctx)
+	defer cancel()
+	stream, err := c.cc.NewStream(ctx, reflectionStreamDesc, c.method)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	resp := &rpb.ServerReflectionResponse{}
+	if err := stream.RecvMsg(resp); err != nil {
+		return nil, err
+	}
+	if e := resp.GetErrorResponse(); e != nil {
+		if codes.Code(e.ErrorCode) == codes.NotFound {
+			return nil, fmt.Errorf("%w: %s", protoregistry.NotFound, e.ErrorMessage)
+		}
+		return nil, status.Error(codes.Code(e.ErrorCode), e.ErrorMessage)
+	}
+	return resp, nil
+}
+
+func (c *reflectionClient) files(req *rpb.ServerReflectionRequest) ([][]byte, error) {
+	resp, err := c.request(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetFileDescriptorResponse().GetFileDescriptorProto(), nil
+}
+
+func (c *reflectionClient) fileByPath(path string) ([][]byte, error) {
+	return c.files(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: path},
+	})
+}
+
+func (c *reflectionClient) fileContainingSymbol(name protoreflect.FullName) ([][]byte, error) {
+	return c.files(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: string(name)},
+	})
+}
+
+func (c *reflectionClient) fileContainingExtension(message protoreflect.FullName, field protoreflect.FieldNumber) ([][]byte, error) {
+	return c.files(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingExtension{
+			FileContainingExtension: &rpb.ExtensionRequest{
+				ContainingType:  string(message),
+				ExtensionNumber: int32(field),
+			},
+		},
+	})
+}
+
+func (c *reflectionClient) extensionNumbers(message protoreflect.FullName) ([]protoreflect.FieldNumber, error) {
+	resp, err := c.request(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_AllExtensionNumbersOfType{AllExtensionNumbersOfType: string(message)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var numbers []protoreflect.FieldNumber
+	for _, n := range resp.GetAllExtensionNumbersResponse().GetExtensionNumber() {
+		numbers = append(numbers, protoreflect.FieldNumber(n))
+	}
+	return numbers, nil
+}
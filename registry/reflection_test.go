@@ -0,0 +1,197 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/reflection/grpc_testing"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+type searchServer struct {
+	grpc_testing.UnimplementedSearchServiceServer
+}
+
+// v1Server registers the v1alpha reflection service as the v1 service,
+// which has the same messages.
+type v1Server struct {
+	*grpc.Server
+}
+
+func (s v1Server) RegisterService(desc *grpc.ServiceDesc, impl interface{}) {
+	v1 := *desc
+	v1.ServiceName = "grpc.reflection.v1.ServerReflection"
+	s.Server.RegisterService(&v1, impl)
+}
+
+// newReflectionConn starts an in-process gRPC server with the reflection
+// service of the given version, if any, and returns a connection to it.
+func newReflectionConn(t *testing.T, version string) *grpc.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	grpc_testing.RegisterSearchServiceServer(s, &searchServer{})
+	switch version {
+	case "v1":
+		reflection.Register(v1Server{s})
+	case "v1alpha":
+		reflection.Register(s)
+	}
+	go s.Serve(lis) //nolint:errcheck
+	t.Cleanup(s.Stop)
+
+	dial := func(context.Context, string) (net.Conn, error) { return lis.Dial() }
+	cc, err := grpc.Dial("bufnet", grpc.WithContextDialer(dial), grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { cc.Close() })
+	return cc
+}
+
+func TestReflectionFiles(t *testing.T) {
+	for _, version := range []string{"v1", "v1alpha"} {
+		t.Run(version, func(t *testing.T) {
+			f, err := NewReflectionFiles(context.Background(), newReflectionConn(t, version))
+			require.NoError(t, err)
+			require.Equal(t, "/grpc.reflection."+version+".ServerReflection/ServerReflectionInfo", f.fetcher.(*reflectionClient).method)
+			require.Equal(t, 0, f.NumFiles())
+
+			mt, err := f.FindMessageByName("grpc.testing.SearchResponse")
+			require.NoError(t, err)
+			require.IsType(t, &dynamicpb.Message{}, mt.New().Interface())
+			// test.proto has no dependencies
+			require.Equal(t, 1, f.NumFiles())
+
+			desc, err := f.FindDescriptorByName("grpc.testing.SearchService.Search")
+			require.NoError(t, err)
+			require.Implements(t, (*protoreflect.MethodDescriptor)(nil), desc)
+			fd, err := f.FindFileByPath("reflection/grpc_testing/proto2.proto")
+			require.NoError(t, err)
+			require.Equal(t, protoreflect.FullName("grpc.testing"), fd.Package())
+
+			_, err = f.FindMessageByName("grpc.testing.Unknown")
+			require.ErrorIs(t, err, protoregistry.NotFound)
+			_, err = f.FindFileByPath("unknown.proto")
+			require.ErrorIs(t, err, protoregistry.NotFound)
+		})
+	}
+}
+
+func TestReflectionFilesExtensions(t *testing.T) {
+	f, err := NewReflectionFiles(context.Background(), newReflectionConn(t, "v1alpha"))
+	require.NoError(t, err)
+
+	et, err := f.FindExtensionByNumber("grpc.testing.ToBeExtended", 17)
+	require.NoError(t, err)
+	require.Equal(t, protoreflect.FullName("grpc.testing.bar"), et.TypeDescriptor().FullName())
+	_, err = f.FindExtensionByNumber("grpc.testing.ToBeExtended", 11)
+	require.ErrorIs(t, err, protoregistry.NotFound)
+
+	var fields []int32
+	for _, et := range f.GetExtensionsOfMessage("grpc.testing.ToBeExtended") {
+		fields = append(fields, int32(et.TypeDescriptor().Number()))
+	}
+	require.ElementsMatch(t, []int32{13, 17, 19, 23, 29}, fields)
+	_, err = f.FindExtensionByName("grpc.testing.nitz")
+	require.NoError(t, err)
+}
+
+func TestReflectionFilesConcurrent(t *testing.T) {
+	f, err := NewReflectionFiles(context.Background(), newReflectionConn(t, "v1alpha"))
+	require.NoError(t, err)
+	names := []protoreflect.FullName{"grpc.testing.SearchRequest", "grpc.testing.Extension", "grpc.testing.AnotherExtension"}
+	errs := make(chan error, 3*len(names))
+	for i := 0; i < cap(errs); i++ {
+		name := names[i%len(names)]
+		go func() {
+			_, err := f.FindMessageByName(name)
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		require.NoError(t, <-errs)
+	}
+	require.Equal(t, 4, f.NumFiles())
+}
+
+func TestReflectionFilesUnsupported(t *testing.T) {
+	_, err := NewReflectionFiles(context.Background(), newReflectionConn(t, ""))
+	require.Error(t, err)
+}
+
+// fakeFetcher serves files by path and counts the requests.
+type fakeFetcher struct {
+	files    map[string]*descriptorpb.FileDescriptorProto
+	requests int
+}
+
+func (f *fakeFetcher) fileByPath(path string) ([][]byte, error) {
+	f.requests++
+	fdp, ok := f.files[path]
+	if !ok {
+		return nil, protoregistry.NotFound
+	}
+	b, err := proto.Marshal(fdp)
+	return [][]byte{b}, err
+}
+
+func (f *fakeFetcher) fileContainingSymbol(protoreflect.FullName) ([][]byte, error) {
+	f.requests++
+	return nil, protoregistry.NotFound
+}
+
+func (f *fakeFetcher) fileContainingExtension(protoreflect.FullName, protoreflect.FieldNumber) ([][]byte, error) {
+	f.requests++
+	return nil, protoregistry.NotFound
+}
+
+func (f *fakeFetcher) extensionNumbers(protoreflect.FullName) ([]protoreflect.FieldNumber, error) {
+	f.requests++
+	return nil, nil
+}
+
+func newFetchingFiles(fetcher fileFetcher) *Files {
+	return &Files{
+		extensions:        newExtensionIndex(),
+		fetcher:           fetcher,
+		fetchedExtensions: map[protoreflect.FullName]bool{},
+		fetchMisses:       map[interface{}]bool{},
+	}
+}
+
+func TestFetchImportCycle(t *testing.T) {
+	fetcher := &fakeFetcher{files: map[string]*descriptorpb.FileDescriptorProto{
+		"a.proto": {Name: proto.String("a.proto"), Dependency: []string{"b.proto"}},
+		"b.proto": {Name: proto.String("b.proto"), Dependency: []string{"a.proto"}},
+	}}
+	f := newFetchingFiles(fetcher)
+	_, err := f.FindFileByPath("a.proto")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "import cycle")
+}
+
+func TestFetchCachesMisses(t *testing.T) {
+	fetcher := &fakeFetcher{}
+	f := newFetchingFiles(fetcher)
+	for i := 0; i < 3; i++ {
+		_, err := f.FindExtensionByNumber("regtest.BaseMessage", 2000)
+		require.ErrorIs(t, err, protoregistry.NotFound)
+		_, err = f.FindDescriptorByName("regtest.Unknown")
+		require.ErrorIs(t, err, protoregistry.NotFound)
+		_, err = f.FindFileByPath("unknown.proto")
+		require.ErrorIs(t, err, protoregistry.NotFound)
+	}
+	require.Equal(t, 3, fetcher.requests)
+
+	_, err := f.FindExtensionByNumber("regtest.BaseMessage", 2001)
+	require.ErrorIs(t, err, protoregistry.NotFound)
+	require.Equal(t, 4, fetcher.requests)
+}
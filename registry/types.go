@@ -1,6 +1,12 @@
 package registry
 
 import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protodesc"
 	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/reflect/protoregistry"
@@ -12,30 +18,139 @@ import (
 // It may panic if any of the messages, enums or extensions in the given
 // registry cannot be added to the clone, however that should never happen.
 func CloneTypes(in *protoregistry.Types) *protoregistry.Types {
-	out := &protoregistry.Types{}
+	out, err := CloneTypesFiltered(in, nil)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
 
+// TypeFilter selects types by their full name.
+type TypeFilter func(protoreflect.FullName) bool
+
+// NamePrefixes returns a TypeFilter selecting the types with one of the
+// given full names, or nested in or in a package with one of the given
+// names. For example "google.protobuf" selects all well-known types, and
+// "google.protobuf.Timestamp" only Timestamp.
+func NamePrefixes(prefixes ...protoreflect.FullName) TypeFilter {
+	return func(name protoreflect.FullName) bool {
+		for _, prefix := range prefixes {
+			if name == prefix || strings.HasPrefix(string(name), string(prefix)+".") {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// CloneTypesFiltered returns a clone of the messages, enums and extensions
+// of the given protoregistry.Types registry selected by filter, or all of
+// them if filter is nil. Extensions are selected by their own full name,
+// not by the name of the message they extend.
+func CloneTypesFiltered(in *protoregistry.Types, filter TypeFilter) (*protoregistry.Types, error) {
+	if filter == nil {
+		filter = func(protoreflect.FullName) bool { return true }
+	}
+	out := &protoregistry.Types{}
+	var err error
 	in.RangeMessages(func(mt protoreflect.MessageType) bool {
-		if err := out.RegisterMessage(mt); err != nil {
-			panic(err)
+		if filter(mt.Descriptor().FullName()) {
+			err = out.RegisterMessage(mt)
 		}
-		return true
+		return err == nil
 	})
-
+	if err != nil {
+		return nil, err
+	}
 	in.RangeEnums(func(et protoreflect.EnumType) bool {
-		if err := out.RegisterEnum(et); err != nil {
-			panic(err)
+		if filter(et.Descriptor().FullName()) {
+			err = out.RegisterEnum(et)
 		}
-		return true
+		return err == nil
 	})
-
-	in.RangeExtensions(func(et protoreflect.ExtensionType) bool {
-		if err := out.RegisterExtension(et); err != nil {
-			panic(err)
+	if err != nil {
+		return nil, err
+	}
+	in.RangeExtensions(func(xt protoreflect.ExtensionType) bool {
+		if filter(xt.TypeDescriptor().FullName()) {
+			err = out.RegisterExtension(xt)
 		}
-		return true
+		return err == nil
 	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
 
-	return out
+// CollisionPolicy decides what DynamicTypesOptions.AddDynamicTypes does
+// with a dynamic type whose name, or for extensions extended message and
+// field number, is already registered.
+type CollisionPolicy int
+
+const (
+	// KeepExisting keeps the registered type and ignores the dynamic type.
+	KeepExisting CollisionPolicy = iota
+	// ReplaceExisting replaces the registered type with the dynamic type.
+	ReplaceExisting
+	// ErrorOnConflict fails if the registered type has a different
+	// definition from the dynamic type. Registered types with the same
+	// definition are kept.
+	ErrorOnConflict
+)
+
+// CollisionReason tells why a dynamic type collides with a registered
+// type.
+type CollisionReason int
+
+const (
+	// CollisionConcrete is a concrete type with the same definition,
+	// typically compiled into the binary.
+	CollisionConcrete CollisionReason = iota + 1
+	// CollisionDuplicate is a dynamic type with the same definition.
+	CollisionDuplicate
+	// CollisionConflict is a type with a different definition or of a
+	// different kind.
+	CollisionConflict
+)
+
+func (r CollisionReason) String() string {
+	switch r {
+	case CollisionConcrete:
+		return "already concrete"
+	case CollisionDuplicate:
+		return "already registered"
+	case CollisionConflict:
+		return "conflicting definition"
+	}
+	return fmt.Sprintf("CollisionReason(%d)", int(r))
+}
+
+// Collision reports a dynamic type that collided with a registered type
+// and was ignored, or replaced the registered type.
+type Collision struct {
+	Name     protoreflect.FullName
+	Reason   CollisionReason
+	Replaced bool
+}
+
+func (c Collision) String() string {
+	action := "ignored"
+	if c.Replaced {
+		action = "replaced"
+	}
+	return fmt.Sprintf("%s: %s, %s", c.Name, c.Reason, action)
+}
+
+// ErrConflict is returned by DynamicTypesOptions.AddDynamicTypes with the
+// ErrorOnConflict policy.
+var ErrConflict = errors.New("conflicting type definition")
+
+// DynamicTypesOptions are options for adding dynamic types to a
+// protoregistry.Types registry.
+type DynamicTypesOptions struct {
+	// Policy decides what happens to types that are already registered.
+	Policy CollisionPolicy
 }
 
 // AddDynamicTypes adds dynamicpb types to the given Types registry for all
@@ -43,44 +158,252 @@ func CloneTypes(in *protoregistry.Types) *protoregistry.Types {
 // already exists in the Types registry, the dynamic type will not be added
 // to replace it, and instead will be ignored.
 func AddDynamicTypes(t *protoregistry.Types, fds *descriptorpb.FileDescriptorSet) error {
-	// FileDescriptor and MessageDescriptor implement the typesContainer interface
-	type typesContainer interface {
-		Messages() protoreflect.MessageDescriptors
-		Enums() protoreflect.EnumDescriptors
-		Extensions() protoreflect.ExtensionDescriptors
-	}
-
-	// addTypes ignores errors from the Types.Register* methods, assuming that
-	// a concrete type is already registered. Concrete types take precedence
-	// over dynamic types as they are compiled into the binary and are more
-	// useful and more easily operated upon.
-	var addTypes func(tc typesContainer)
-	addTypes = func(tc typesContainer) {
-		mds := tc.Messages()
-		for i := 0; i < mds.Len(); i++ {
-			md := mds.Get(i)
-			t.RegisterMessage(dynamicpb.NewMessageType(md)) //nolint:errcheck
-			addTypes(md)
+	_, err := DynamicTypesOptions{Policy: KeepExisting}.AddDynamicTypes(t, fds)
+	return err
+}
+
+// AddDynamicTypes adds dynamicpb types to the given Types registry for all
+// Messages, Enums and Extensions in the given FileDescriptorSet, resolving
+// collisions with registered types, and among the types of the
+// FileDescriptorSet, according to the policy. It returns the collisions in
+// the order of the FileDescriptorSet. With the ErrorOnConflict policy,
+// nothing is added if there is a conflict. The ReplaceExisting policy
+// cannot be used with protoregistry.GlobalTypes, as registered types are
+// replaced by rebuilding the registry. It also removes the registered
+// extensions of replaced messages, leaving those of the FileDescriptorSet.
+func (o DynamicTypesOptions) AddDynamicTypes(t *protoregistry.Types, fds *descriptorpb.FileDescriptorSet) ([]Collision, error) {
+	if o.Policy == ReplaceExisting && t == protoregistry.GlobalTypes {
+		return nil, errors.New("cannot replace types of protoregistry.GlobalTypes")
+	}
+	files, err := protodesc.FileOptions{AllowUnresolvable: true}.NewFiles(fds)
+	if err != nil {
+		return nil, err
+	}
+	var dynamicTypes []interface{}
+	for _, fdp := range fds.File {
+		fd, err := files.FindFileByPath(fdp.GetName())
+		if err != nil {
+			return nil, err
 		}
+		dynamicTypes = appendDynamicTypes(dynamicTypes, fd)
+	}
 
-		enumds := tc.Enums()
-		for i := 0; i < enumds.Len(); i++ {
-			t.RegisterEnum(dynamicpb.NewEnumType(enumds.Get(i))) //nolint:errcheck
+	var collisions []Collision
+	// add holds the dynamic types to be added, also registered in
+	// incoming to find collisions among them.
+	var add []interface{}
+	incoming := &protoregistry.Types{}
+	replaced := map[protoreflect.FullName]bool{}
+	for _, dt := range dynamicTypes {
+		desc := typeDescriptor(dt)
+		existing := findExisting(t, desc)
+		existingIncoming := findExisting(incoming, desc)
+		if len(existing) == 0 && len(existingIncoming) == 0 {
+			add = append(add, dt)
+			if err := registerType(incoming, dt); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// types of the FileDescriptorSet collide with the ones added before
+		// them, which already replace registered types
+		c := Collision{Name: desc.FullName()}
+		if len(existingIncoming) > 0 {
+			c.Reason = collisionReason(desc, existingIncoming)
+		} else {
+			c.Reason = collisionReason(desc, existing)
+		}
+		switch o.Policy {
+		case ErrorOnConflict:
+			if c.Reason == CollisionConflict {
+				return nil, fmt.Errorf("%w: %s", ErrConflict, c.Name)
+			}
+		case ReplaceExisting:
+			c.Replaced = true
+			for _, et := range existing {
+				replaced[typeDescriptor(et).FullName()] = true
+			}
+			if len(existingIncoming) > 0 {
+				if add, incoming, err = removeTypes(add, existingIncoming); err != nil {
+					return nil, err
+				}
+			}
+			add = append(add, dt)
+			if err := registerType(incoming, dt); err != nil {
+				return nil, err
+			}
 		}
+		collisions = append(collisions, c)
+	}
 
-		extds := tc.Extensions()
-		for i := 0; i < extds.Len(); i++ {
-			t.RegisterExtension(dynamicpb.NewExtensionType(extds.Get(i))) //nolint:errcheck
+	if len(replaced) > 0 {
+		// extensions of replaced messages extend the old descriptors
+		t.RangeExtensions(func(xt protoreflect.ExtensionType) bool {
+			xd := xt.TypeDescriptor()
+			if replaced[xd.ContainingMessage().FullName()] {
+				replaced[xd.FullName()] = true
+			}
+			return true
+		})
+		kept, err := CloneTypesFiltered(t, func(name protoreflect.FullName) bool { return !replaced[name] })
+		if err != nil {
+			return nil, err
+		}
+		*t = *kept
+	}
+	for _, dt := range add {
+		if err := registerType(t, dt); err != nil {
+			return nil, err
 		}
 	}
+	return collisions, nil
+}
 
-	files, err := protodesc.FileOptions{AllowUnresolvable: true}.NewFiles(fds)
-	if err != nil {
-		return err
+// removeTypes returns types without the types of the same full names as
+// remove, and a registry of the remaining types.
+func removeTypes(types, remove []interface{}) ([]interface{}, *protoregistry.Types, error) {
+	removed := map[protoreflect.FullName]bool{}
+	for _, typ := range remove {
+		removed[typeDescriptor(typ).FullName()] = true
+	}
+	var kept []interface{}
+	registry := &protoregistry.Types{}
+	for _, typ := range types {
+		if removed[typeDescriptor(typ).FullName()] {
+			continue
+		}
+		kept = append(kept, typ)
+		if err := registerType(registry, typ); err != nil {
+			return nil, nil, err
+		}
+	}
+	return kept, registry, nil
+}
+
+// typesContainer is implemented by FileDescriptor and MessageDescriptor.
+type typesContainer interface {
+	Messages() protoreflect.MessageDescriptors
+	Enums() protoreflect.EnumDescriptors
+	Extensions() protoreflect.ExtensionDescriptors
+}
+
+// appendDynamicTypes appends dynamic message, enum and extension types for
+// all the types in tc and its nested messages to types.
+func appendDynamicTypes(types []interface{}, tc typesContainer) []interface{} {
+	mds := tc.Messages()
+	for i := 0; i < mds.Len(); i++ {
+		md := mds.Get(i)
+		types = append(types, dynamicpb.NewMessageType(md))
+		types = appendDynamicTypes(types, md)
+	}
+
+	enumds := tc.Enums()
+	for i := 0; i < enumds.Len(); i++ {
+		types = append(types, dynamicpb.NewEnumType(enumds.Get(i)))
+	}
+
+	extds := tc.Extensions()
+	for i := 0; i < extds.Len(); i++ {
+		types = append(types, dynamicpb.NewExtensionType(extds.Get(i)))
+	}
+	return types
+}
+
+func typeDescriptor(typ interface{}) protoreflect.Descriptor {
+	switch typ := typ.(type) {
+	case protoreflect.MessageType:
+		return typ.Descriptor()
+	case protoreflect.EnumType:
+		return typ.Descriptor()
+	case protoreflect.ExtensionType:
+		return typ.TypeDescriptor().Descriptor()
+	}
+	panic(fmt.Sprintf("%T is not a type", typ))
+}
+
+func registerType(t *protoregistry.Types, typ interface{}) error {
+	switch typ := typ.(type) {
+	case protoreflect.MessageType:
+		return t.RegisterMessage(typ)
+	case protoreflect.EnumType:
+		return t.RegisterEnum(typ)
+	case protoreflect.ExtensionType:
+		return t.RegisterExtension(typ)
+	}
+	panic(fmt.Sprintf("%T is not a type", typ))
+}
+
+// findExisting returns the registered types colliding with a type of the
+// given descriptor: the type of the same name, and for extensions the
+// extension of the same message and field number.
+func findExisting(t *protoregistry.Types, desc protoreflect.Descriptor) []interface{} {
+	var existing []interface{}
+	name := desc.FullName()
+	if mt, err := t.FindMessageByName(name); err == nil {
+		existing = append(existing, mt)
+	} else if et, err := t.FindEnumByName(name); err == nil {
+		existing = append(existing, et)
+	} else if xt, err := t.FindExtensionByName(name); err == nil {
+		existing = append(existing, xt)
+	}
+	if xd, ok := desc.(protoreflect.ExtensionDescriptor); ok {
+		xt, err := t.FindExtensionByNumber(xd.ContainingMessage().FullName(), xd.Number())
+		if err == nil && xt.TypeDescriptor().FullName() != name {
+			existing = append(existing, xt)
+		}
+	}
+	return existing
+}
+
+func collisionReason(desc protoreflect.Descriptor, existing []interface{}) CollisionReason {
+	if len(existing) != 1 || !sameDefinition(desc, typeDescriptor(existing[0])) {
+		return CollisionConflict
+	}
+	if isDynamic(existing[0]) {
+		return CollisionDuplicate
+	}
+	return CollisionConcrete
+}
+
+// dynamicPkgPath is the package path of the dynamicpb types.
+var dynamicPkgPath = reflect.TypeOf(dynamicpb.Message{}).PkgPath()
+
+func isDynamic(typ interface{}) bool {
+	t := reflect.TypeOf(typ)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.PkgPath() == dynamicPkgPath
+}
+
+// sameDefinition returns true if the descriptors define the same type.
+// JSON names are not compared, as protoc only sets them in the
+// descriptors it passes to plugins.
+func sameDefinition(a, b protoreflect.Descriptor) bool {
+	return proto.Equal(definition(a), definition(b))
+}
+
+func definition(desc protoreflect.Descriptor) proto.Message {
+	switch desc := desc.(type) {
+	case protoreflect.MessageDescriptor:
+		dp := protodesc.ToDescriptorProto(desc)
+		clearJSONNames(dp)
+		return dp
+	case protoreflect.EnumDescriptor:
+		return protodesc.ToEnumDescriptorProto(desc)
+	case protoreflect.FieldDescriptor:
+		fdp := protodesc.ToFieldDescriptorProto(desc)
+		fdp.JsonName = nil
+		return fdp
 	}
-	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
-		addTypes(fd)
-		return true
-	})
 	return nil
 }
+
+func clearJSONNames(dp *descriptorpb.DescriptorProto) {
+	for _, fdp := range append(dp.Field, dp.Extension...) {
+		fdp.JsonName = nil
+	}
+	for _, nested := range dp.NestedType {
+		clearJSONNames(nested)
+	}
+}
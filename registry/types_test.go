@@ -7,6 +7,7 @@ import (
 	"github.com/stretchr/testify/require"
 	_ "google.golang.org/genproto/googleapis/api/annotations"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/reflect/protoregistry"
 	"google.golang.org/protobuf/types/descriptorpb"
 	_ "google.golang.org/protobuf/types/descriptorpb"
@@ -75,3 +76,236 @@ func newFDS(t *testing.T) *descriptorpb.FileDescriptorSet {
 	require.NoError(t, err)
 	return &fds
 }
+
+func TestAddDynamicTypesCollisions(t *testing.T) {
+	types := CloneTypes(protoregistry.GlobalTypes)
+	collisions, err := DynamicTypesOptions{Policy: KeepExisting}.AddDynamicTypes(types, newFDS(t))
+	require.NoError(t, err)
+
+	reasons := map[protoreflect.FullName]CollisionReason{}
+	for _, c := range collisions {
+		require.False(t, c.Replaced)
+		reasons[c.Name] = c.Reason
+	}
+	require.Equal(t, CollisionConcrete, reasons["google.protobuf.FileDescriptorSet"])
+	require.Equal(t, CollisionConcrete, reasons["google.api.http"])
+	// regtest.pb was built with an older descriptor.proto than the one
+	// compiled into protobuf-go
+	require.Equal(t, CollisionConflict, reasons["google.protobuf.FileDescriptorProto"])
+	require.NotContains(t, reasons, protoreflect.FullName("regtest.BaseMessage"))
+
+	mt, err := types.FindMessageByName("google.protobuf.FileDescriptorProto")
+	require.NoError(t, err)
+	require.IsType(t, &descriptorpb.FileDescriptorProto{}, mt.New().Interface())
+	require.Equal(t, "google.protobuf.FileDescriptorSet: already concrete, ignored", Collision{Name: "google.protobuf.FileDescriptorSet", Reason: CollisionConcrete}.String())
+}
+
+func TestAddDynamicTypesDuplicates(t *testing.T) {
+	types := &protoregistry.Types{}
+	collisions, err := DynamicTypesOptions{}.AddDynamicTypes(types, newFDS(t))
+	require.NoError(t, err)
+	require.Empty(t, collisions)
+
+	collisions, err = DynamicTypesOptions{Policy: ErrorOnConflict}.AddDynamicTypes(types, newFDS(t))
+	require.NoError(t, err)
+	require.Len(t, collisions, 34+7+5)
+	for _, c := range collisions {
+		require.Equal(t, CollisionDuplicate, c.Reason, c.Name)
+	}
+}
+
+func TestAddDynamicTypesReplace(t *testing.T) {
+	types := CloneTypes(protoregistry.GlobalTypes)
+	numMessages := types.NumMessages()
+	collisions, err := DynamicTypesOptions{Policy: ReplaceExisting}.AddDynamicTypes(types, newFDS(t))
+	require.NoError(t, err)
+	require.NotEmpty(t, collisions)
+	for _, c := range collisions {
+		require.True(t, c.Replaced)
+	}
+	require.Equal(t, numMessages+4, types.NumMessages()) // regtest messages and Empty
+
+	mt, err := types.FindMessageByName("google.protobuf.FileDescriptorSet")
+	require.NoError(t, err)
+	require.IsType(t, &dynamicpb.Message{}, mt.New().Interface())
+	xt, err := types.FindExtensionByNumber("google.protobuf.MethodOptions", 72295728)
+	require.NoError(t, err)
+	require.True(t, isDynamic(xt))
+}
+
+func TestAddDynamicTypesErrorOnConflict(t *testing.T) {
+	types := CloneTypes(protoregistry.GlobalTypes)
+	numMessages := types.NumMessages()
+	_, err := DynamicTypesOptions{Policy: ErrorOnConflict}.AddDynamicTypes(types, newFDS(t))
+	require.ErrorIs(t, err, ErrConflict)
+	require.Equal(t, numMessages, types.NumMessages())
+	_, err = types.FindMessageByName("regtest.BaseMessage")
+	require.ErrorIs(t, err, protoregistry.NotFound)
+}
+
+func TestAddDynamicTypesExtensionNumberConflict(t *testing.T) {
+	types := &protoregistry.Types{}
+	require.NoError(t, AddDynamicTypes(types, newFDS(t)))
+
+	other := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:       proto.String("other.proto"),
+		Package:    proto.String("other"),
+		Dependency: []string{"regtest.proto"},
+		Extension: []*descriptorpb.FieldDescriptorProto{{
+			Name:     proto.String("other_ef1"),
+			Number:   proto.Int32(1000),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+			Extendee: proto.String(".regtest.BaseMessage"),
+		}},
+	}}}
+	collisions, err := DynamicTypesOptions{}.AddDynamicTypes(types, other)
+	require.NoError(t, err)
+	require.Equal(t, []Collision{{Name: "other.other_ef1", Reason: CollisionConflict}}, collisions)
+
+	_, err = DynamicTypesOptions{Policy: ReplaceExisting}.AddDynamicTypes(types, other)
+	require.NoError(t, err)
+	xt, err := types.FindExtensionByNumber("regtest.BaseMessage", 1000)
+	require.NoError(t, err)
+	require.Equal(t, protoreflect.FullName("other.other_ef1"), xt.TypeDescriptor().FullName())
+	_, err = types.FindExtensionByName("regtest.ef1")
+	require.ErrorIs(t, err, protoregistry.NotFound)
+}
+
+func TestAddDynamicTypesIncomingConflict(t *testing.T) {
+	extension := func(file, name string) *descriptorpb.FileDescriptorProto {
+		return &descriptorpb.FileDescriptorProto{
+			Name:       proto.String(file),
+			Package:    proto.String("other"),
+			Dependency: []string{"google/protobuf/descriptor.proto"},
+			Extension: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String(name),
+				Number:   proto.Int32(50000),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+				Extendee: proto.String(".google.protobuf.FieldOptions"),
+			}},
+		}
+	}
+	fds := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+		extension("a.proto", "a"),
+		extension("b.proto", "b"),
+	}}
+
+	types := &protoregistry.Types{}
+	require.NoError(t, AddDynamicTypes(types, fds))
+	xt, err := types.FindExtensionByNumber("google.protobuf.FieldOptions", 50000)
+	require.NoError(t, err)
+	require.Equal(t, protoreflect.FullName("other.a"), xt.TypeDescriptor().FullName())
+
+	types = &protoregistry.Types{}
+	collisions, err := DynamicTypesOptions{}.AddDynamicTypes(types, fds)
+	require.NoError(t, err)
+	require.Equal(t, []Collision{{Name: "other.b", Reason: CollisionConflict}}, collisions)
+
+	types = &protoregistry.Types{}
+	collisions, err = DynamicTypesOptions{Policy: ReplaceExisting}.AddDynamicTypes(types, fds)
+	require.NoError(t, err)
+	require.Equal(t, []Collision{{Name: "other.b", Reason: CollisionConflict, Replaced: true}}, collisions)
+	xt, err = types.FindExtensionByNumber("google.protobuf.FieldOptions", 50000)
+	require.NoError(t, err)
+	require.Equal(t, protoreflect.FullName("other.b"), xt.TypeDescriptor().FullName())
+	_, err = types.FindExtensionByName("other.a")
+	require.ErrorIs(t, err, protoregistry.NotFound)
+
+	types = &protoregistry.Types{}
+	_, err = DynamicTypesOptions{Policy: ErrorOnConflict}.AddDynamicTypes(types, fds)
+	require.ErrorIs(t, err, ErrConflict)
+	require.Equal(t, 0, types.NumExtensions())
+}
+
+func TestAddDynamicTypesReplaceExtended(t *testing.T) {
+	file := func(name string, fields []string, extensions ...string) *descriptorpb.FileDescriptorProto {
+		fdp := &descriptorpb.FileDescriptorProto{
+			Name:        proto.String(name),
+			Package:     proto.String("ext"),
+			MessageType: []*descriptorpb.DescriptorProto{{Name: proto.String("Base"), ExtensionRange: []*descriptorpb.DescriptorProto_ExtensionRange{{Start: proto.Int32(100), End: proto.Int32(200)}}}},
+		}
+		for i, f := range fields {
+			fdp.MessageType[0].Field = append(fdp.MessageType[0].Field, &descriptorpb.FieldDescriptorProto{
+				Name:   proto.String(f),
+				Number: proto.Int32(int32(i + 1)),
+				Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:   descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+			})
+		}
+		for i, x := range extensions {
+			fdp.Extension = append(fdp.Extension, &descriptorpb.FieldDescriptorProto{
+				Name:     proto.String(x),
+				Number:   proto.Int32(int32(100 + i)),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+				Extendee: proto.String(".ext.Base"),
+			})
+		}
+		return fdp
+	}
+	types := &protoregistry.Types{}
+	old := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file("old.proto", []string{"a"}, "old_x")}}
+	require.NoError(t, AddDynamicTypes(types, old))
+
+	// the extension of the replaced message is removed
+	replacement := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file("new.proto", []string{"a", "b"})}}
+	collisions, err := DynamicTypesOptions{Policy: ReplaceExisting}.AddDynamicTypes(types, replacement)
+	require.NoError(t, err)
+	require.Equal(t, []Collision{{Name: "ext.Base", Reason: CollisionConflict, Replaced: true}}, collisions)
+	_, err = types.FindExtensionByName("ext.old_x")
+	require.ErrorIs(t, err, protoregistry.NotFound)
+	_, err = types.FindExtensionByNumber("ext.Base", 100)
+	require.ErrorIs(t, err, protoregistry.NotFound)
+
+	// extensions of the replacing FileDescriptorSet extend the new message
+	replacement.File[0] = file("new.proto", []string{"a", "b", "c"}, "new_x")
+	_, err = DynamicTypesOptions{Policy: ReplaceExisting}.AddDynamicTypes(types, replacement)
+	require.NoError(t, err)
+	mt, err := types.FindMessageByName("ext.Base")
+	require.NoError(t, err)
+	xt, err := types.FindExtensionByNumber("ext.Base", 100)
+	require.NoError(t, err)
+	require.Equal(t, protoreflect.FullName("ext.new_x"), xt.TypeDescriptor().FullName())
+	require.Equal(t, mt.Descriptor(), xt.TypeDescriptor().ContainingMessage())
+	require.Equal(t, 1, types.NumExtensions())
+}
+
+func TestAddDynamicTypesReplaceGlobalTypes(t *testing.T) {
+	_, err := DynamicTypesOptions{Policy: ReplaceExisting}.AddDynamicTypes(protoregistry.GlobalTypes, newFDS(t))
+	require.Error(t, err)
+}
+
+func TestCloneTypesFiltered(t *testing.T) {
+	all, err := CloneTypesFiltered(protoregistry.GlobalTypes, nil)
+	require.NoError(t, err)
+	require.Exactly(t, protoregistry.GlobalTypes, all)
+
+	types := &protoregistry.Types{}
+	require.NoError(t, AddDynamicTypes(types, newFDS(t)))
+	filtered, err := CloneTypesFiltered(types, NamePrefixes("regtest", "google.protobuf.FieldOptions"))
+	require.NoError(t, err)
+	// regtest: 3 messages, 4 extensions; FieldOptions: 1 message, 2 enums
+	require.Equal(t, 4, filtered.NumMessages())
+	require.Equal(t, 2, filtered.NumEnums())
+	require.Equal(t, 4, filtered.NumExtensions())
+	_, err = filtered.FindMessageByName("regtest.ExtensionMessage.NestedExtension")
+	require.NoError(t, err)
+	_, err = filtered.FindEnumByName("google.protobuf.FieldOptions.JSType")
+	require.NoError(t, err)
+	_, err = filtered.FindMessageByName("google.protobuf.FieldOptionsFoo")
+	require.ErrorIs(t, err, protoregistry.NotFound)
+	_, err = filtered.FindMessageByName("google.protobuf.MethodOptions")
+	require.ErrorIs(t, err, protoregistry.NotFound)
+}
+
+func TestNamePrefixes(t *testing.T) {
+	filter := NamePrefixes("google.protobuf", "regtest.BaseMessage")
+	require.True(t, filter("google.protobuf.Timestamp"))
+	require.True(t, filter("regtest.BaseMessage"))
+	require.True(t, filter("regtest.BaseMessage.Nested"))
+	require.False(t, filter("google.protobufx.Timestamp"))
+	require.False(t, filter("regtest.BaseMessageFoo"))
+	require.False(t, filter("regtest"))
+}
@@ -0,0 +1,252 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Watcher loads the protosets in a directory into a Files and reloads them
+// when the directory changes. Protosets are the files named *.pb or
+// *.protoset in the directory and its subdirectories. A reloaded Files is
+// swapped in atomically once it has been built successfully; if the
+// protosets cannot be loaded, the last good Files is kept.
+type Watcher struct {
+	dir   string
+	files atomic.Value // *Files
+
+	mu      sync.Mutex // guards stamp and symbols and serializes reloads
+	stamp   string     // of the last scan, or its error
+	symbols map[protoreflect.FullName]bool
+
+	subMu       sync.Mutex // guards subscribers and nextID
+	subscribers map[int]func(Change)
+	nextID      int
+}
+
+// Change describes a reload of the directory of a Watcher. Added and
+// Removed are the full names of the messages, enums, extensions and
+// services added and removed, sorted. If the directory could not be
+// loaded, Err is set and the previous Files are kept.
+type Change struct {
+	Added   []protoreflect.FullName
+	Removed []protoreflect.FullName
+	Err     error
+}
+
+// NewWatcher returns a Watcher of the given directory, failing if its
+// protosets cannot be loaded.
+func NewWatcher(dir string) (*Watcher, error) {
+	w := &Watcher{dir: dir, subscribers: map[int]func(Change){}}
+	w.files.Store(&Files{extensions: newExtensionIndex()})
+	if _, err := w.Reload(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Files returns the Files of the last successful load.
+func (w *Watcher) Files() *Files {
+	return w.files.Load().(*Files)
+}
+
+// Subscribe registers fn to be called with every change of the directory
+// and returns a function that unsubscribes it. fn is called from the
+// goroutine reloading the directory, one change at a time, and must not
+// call Reload.
+func (w *Watcher) Subscribe(fn func(Change)) (unsubscribe func()) {
+	w.subMu.Lock()
+	defer w.subMu.Unlock()
+	id := w.nextID
+	w.nextID++
+	w.subscribers[id] = fn
+	return func() {
+		w.subMu.Lock()
+		defer w.subMu.Unlock()
+		delete(w.subscribers, id)
+	}
+}
+
+// Run polls the directory for changes at the given interval and reloads
+// it when it changes, until ctx is done.
+func (w *Watcher) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			w.Reload() //nolint:errcheck // reported to subscribers
+		}
+	}
+}
+
+// Reload reloads the directory if any protoset has been added, removed or
+// modified since the last reload, and notifies the subscribers. It
+// returns whether the directory changed and the error loading it, if any.
+// Errors that persist, such as a missing directory, are only reported
+// once.
+func (w *Watcher) Reload() (changed bool, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	paths, stamp, err := w.scan()
+	if err != nil {
+		stamp = "error: " + err.Error()
+	}
+	if stamp == w.stamp {
+		return false, nil
+	}
+	w.stamp = stamp
+	var files *Files
+	if err == nil {
+		files, err = loadProtosets(paths)
+	}
+	if err != nil {
+		err = fmt.Errorf("cannot load %s: %w", w.dir, err)
+		w.notify(Change{Err: err})
+		return true, err
+	}
+
+	symbols := fileSymbols(files)
+	change := Change{}
+	for name := range symbols {
+		if !w.symbols[name] {
+			change.Added = append(change.Added, name)
+		}
+	}
+	for name := range w.symbols {
+		if !symbols[name] {
+			change.Removed = append(change.Removed, name)
+		}
+	}
+	sortNames(change.Added)
+	sortNames(change.Removed)
+	w.files.Store(files)
+	w.symbols = symbols
+	w.notify(change)
+	return true, nil
+}
+
+// notify calls the subscribers in the order they subscribed.
+func (w *Watcher) notify(c Change) {
+	w.subMu.Lock()
+	ids := make([]int, 0, len(w.subscribers))
+	for id := range w.subscribers {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	subscribers := make([]func(Change), len(ids))
+	for i, id := range ids {
+		subscribers[i] = w.subscribers[id]
+	}
+	w.subMu.Unlock()
+	for _, fn := range subscribers {
+		fn(c)
+	}
+}
+
+// scan returns the sorted paths of the protosets in the directory and a
+// stamp of their names, sizes and modification times.
+func (w *Watcher) scan() ([]string, string, error) {
+	var paths []string
+	stamp := ""
+	err := filepath.WalkDir(w.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !isProtoset(path) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		paths = append(paths, path)
+		stamp += fmt.Sprintf("%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	return paths, stamp, err
+}
+
+func isProtoset(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".pb" || ext == ".protoset"
+}
+
+// loadProtosets returns the Files of all the files in the given protosets.
+// A file may be contained in several protosets if it is the same in all of
+// them.
+func loadProtosets(paths []string) (*Files, error) {
+	fds := &descriptorpb.FileDescriptorSet{}
+	origins := map[string]string{}
+	files := map[string]*descriptorpb.FileDescriptorProto{}
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		set := &descriptorpb.FileDescriptorSet{}
+		if err := proto.Unmarshal(b, set); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, fdp := range set.File {
+			name := fdp.GetName()
+			if prev, ok := files[name]; ok {
+				if !proto.Equal(prev, fdp) {
+					return nil, fmt.Errorf("%s: conflicting definitions of %s in %s", path, name, origins[name])
+				}
+				continue
+			}
+			files[name] = fdp
+			origins[name] = path
+			fds.File = append(fds.File, fdp)
+		}
+	}
+	return NewFiles(fds)
+}
+
+// fileSymbols returns the full names of the messages, enums, extensions
+// and services of all files.
+func fileSymbols(files *Files) map[protoreflect.FullName]bool {
+	symbols := map[protoreflect.FullName]bool{}
+	var addTypes func(tc typesContainer)
+	addTypes = func(tc typesContainer) {
+		mds := tc.Messages()
+		for i := 0; i < mds.Len(); i++ {
+			symbols[mds.Get(i).FullName()] = true
+			addTypes(mds.Get(i))
+		}
+		eds := tc.Enums()
+		for i := 0; i < eds.Len(); i++ {
+			symbols[eds.Get(i).FullName()] = true
+		}
+		xds := tc.Extensions()
+		for i := 0; i < xds.Len(); i++ {
+			symbols[xds.Get(i).FullName()] = true
+		}
+	}
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		addTypes(fd)
+		sds := fd.Services()
+		for i := 0; i < sds.Len(); i++ {
+			symbols[sds.Get(i).FullName()] = true
+		}
+		return true
+	})
+	return symbols
+}
+
+func sortNames(names []protoreflect.FullName) {
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+}
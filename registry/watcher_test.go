@@ -0,0 +1,168 @@
+package registry
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// otherFDS returns a file descriptor set with an extension of
+// regtest.BaseMessage in a package "other".
+func otherFDS() *descriptorpb.FileDescriptorSet {
+	return &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:       proto.String("other.proto"),
+		Package:    proto.String("other"),
+		Dependency: []string{"regtest.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("OtherMessage"),
+		}},
+		Extension: []*descriptorpb.FieldDescriptorProto{{
+			Name:     proto.String("other_ef"),
+			Number:   proto.Int32(2000),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+			Extendee: proto.String(".regtest.BaseMessage"),
+		}},
+	}}}
+}
+
+func writeProtoset(t *testing.T, path string, fds *descriptorpb.FileDescriptorSet) {
+	t.Helper()
+	b, err := proto.Marshal(fds)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, b, 0o666))
+}
+
+func TestWatcherReload(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoset(t, filepath.Join(dir, "regtest.pb"), newFDS(t))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("not a protoset"), 0o666))
+
+	w, err := NewWatcher(dir)
+	require.NoError(t, err)
+	_, err = w.Files().FindMessageByName("regtest.BaseMessage")
+	require.NoError(t, err)
+
+	var changes []Change
+	unsubscribe := w.Subscribe(func(c Change) { changes = append(changes, c) })
+	changed, err := w.Reload()
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	// add a protoset in a subdirectory, also containing regtest.proto
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0o777))
+	other := otherFDS()
+	other.File = append(newFDS(t).File, other.File...)
+	writeProtoset(t, filepath.Join(dir, "sub", "other.protoset"), other)
+	changed, err = w.Reload()
+	require.NoError(t, err)
+	require.True(t, changed)
+	want := Change{Added: []protoreflect.FullName{"other.OtherMessage", "other.other_ef"}}
+	require.Equal(t, []Change{want}, changes)
+	et, err := w.Files().FindExtensionByNumber("regtest.BaseMessage", 2000)
+	require.NoError(t, err)
+	require.Equal(t, protoreflect.FullName("other.other_ef"), et.TypeDescriptor().FullName())
+
+	// a bad protoset keeps the last good files
+	good := w.Files()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bad.pb"), []byte("bad"), 0o666))
+	_, err = w.Reload()
+	require.Error(t, err)
+	require.Same(t, good, w.Files())
+	require.Len(t, changes, 2)
+	require.Error(t, changes[1].Err)
+	changed, err = w.Reload()
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	// removing protosets removes their symbols
+	require.NoError(t, os.Remove(filepath.Join(dir, "bad.pb")))
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, "sub")))
+	_, err = w.Reload()
+	require.NoError(t, err)
+	require.Equal(t, want.Added, changes[2].Removed)
+	require.Empty(t, changes[2].Added)
+	_, err = w.Files().FindMessageByName("other.OtherMessage")
+	require.ErrorIs(t, err, protoregistry.NotFound)
+
+	// a scan error is reported once while it persists
+	moved := dir + ".moved"
+	require.NoError(t, os.Rename(dir, moved))
+	_, err = w.Reload()
+	require.Error(t, err)
+	changed, err = w.Reload()
+	require.NoError(t, err)
+	require.False(t, changed)
+	require.Len(t, changes, 4)
+	require.Error(t, changes[3].Err)
+	require.NoError(t, os.Rename(moved, dir))
+	changed, err = w.Reload()
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.Equal(t, Change{}, changes[4])
+
+	unsubscribe()
+	writeProtoset(t, filepath.Join(dir, "other.pb"), otherFDS())
+	_, err = w.Reload()
+	require.NoError(t, err)
+	require.Len(t, changes, 5)
+}
+
+func TestWatcherEmptyDir(t *testing.T) {
+	w, err := NewWatcher(t.TempDir())
+	require.NoError(t, err)
+	_, err = w.Files().FindMessageByName("regtest.BaseMessage")
+	require.ErrorIs(t, err, protoregistry.NotFound)
+}
+
+func TestWatcherConflict(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoset(t, filepath.Join(dir, "a.pb"), newFDS(t))
+	fds := newFDS(t)
+	for _, fdp := range fds.File {
+		if fdp.GetName() == "regtest.proto" {
+			fdp.MessageType = fdp.MessageType[1:]
+		}
+	}
+	writeProtoset(t, filepath.Join(dir, "b.pb"), fds)
+	_, err := NewWatcher(dir)
+	require.Error(t, err)
+}
+
+func TestWatcherRun(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoset(t, filepath.Join(dir, "regtest.pb"), newFDS(t))
+	w, err := NewWatcher(dir)
+	require.NoError(t, err)
+	changes := make(chan Change, 1)
+	w.Subscribe(func(c Change) { changes <- c })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx, 10*time.Millisecond) }()
+
+	writeProtoset(t, filepath.Join(dir, "other.pb"), otherFDS())
+	timeout := time.After(5 * time.Second)
+	for loaded := false; !loaded; {
+		select {
+		case c := <-changes:
+			// the protoset may be seen partly written first
+			loaded = c.Err == nil
+			if loaded {
+				require.Contains(t, c.Added, protoreflect.FullName("other.OtherMessage"))
+			}
+		case <-timeout:
+			require.Fail(t, "no change reported")
+		}
+	}
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+}